@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+const longHelp = `Fetch from the primary remote and update local branches.
+
+If a local branch is outdated, fast-forward it.
+If a local branch contains unpushed work, warn about it.
+If a branch seems merged and its upstream was deleted, delete it.
+
+Running gh sync without a subcommand is the same as gh sync run.`
+
+const branchArgsHelp = `Branch names or glob patterns given as arguments, or with --branch, limit
+the run to matching branches. --exclude skips matching branches.`
+
+const configHelp = `Preferences are read from ~/.config/gh-sync/config.yml, then from
+gh-sync.* keys in git config, and finally from flags:
+
+  gh-sync.remotes       Preferred remotes in order, e.g. "upstream, origin"
+  gh-sync.protect       Glob of branches never to change (repeatable)
+  gh-sync.delete        Deletion policy: merged (default), strict or never
+  gh-sync.format        Output format: text (default) or json
+  gh-sync.color         When to use colors: auto (default), always or never
+  gh-sync.verbose       Log each git command, its errors and each branch decision to stderr
+  gh-sync.fetchTimeout  Limit for each fetch attempt (default 5m)
+  gh-sync.fetchRetries  Retries after a failed fetch (default 2)`
+
+const exitCodesHelp = `Exit codes:
+  0    Every branch is clean
+  1    A git operation failed part way through
+  2    Invalid arguments
+  3    Warnings about unpushed or unmerged branches
+  4    Fetching from the remote failed
+  5    Not a git repository, or no remotes configured
+  130  Interrupted by Ctrl-C before finishing`
+
+// errInterrupted reports a run that was stopped by a signal.
+var errInterrupted = errors.New("interrupted; branches not reported above were left as they were")
+
+// run executes the command line and returns the process exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	ctx, stop := notifyInterrupt(context.Background(), stderr)
+	defer stop()
+
+	defer closeLogging()
+
+	var res result
+	errOut := newTheme(colorAuto, stderr)
+	cmd := newRootCmd(stdout, stderr, &res, &errOut)
+	cmd.SetArgs(args)
+
+	err := cmd.ExecuteContext(ctx)
+	if err != nil && ctx.Err() != nil {
+		err = &exitError{code: exitInterrupted, err: errInterrupted}
+	}
+	if err != nil {
+		errOut.errorf(stderr, "%s", err)
+	}
+	if timings != nil {
+		timings.print(stderr)
+	}
+	return exitCode(res, err)
+}
+
+// notifyInterrupt returns a context that is cancelled by the first SIGINT or
+// SIGTERM, so the run can stop after the step in progress. A second signal
+// gets the default behaviour and ends the process at once.
+func notifyInterrupt(parent context.Context, stderr io.Writer) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			fmt.Fprintln(stderr, "Interrupted; finishing the current step. Press Ctrl-C again to quit now.")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// newRootCmd builds the gh sync command tree. Whichever command runs stores
+// its outcome in res so run can map it to an exit code, and the theme for
+// stderr in errOut so run can report an error in the same style.
+func newRootCmd(stdout, stderr io.Writer, res *result, errOut *theme) *cobra.Command {
+	// flags holds values exactly as given on the command line; opts is the
+	// config merged with them, resolved before any command runs.
+	var flags, opts options
+
+	root := &cobra.Command{
+		Short: "Sync local branches with the primary remote",
+		Long:  longHelp + "\n\n" + branchArgsHelp + "\n\n" + configHelp + "\n\n" + exitCodesHelp,
+		Annotations: map[string]string{
+			cobra.CommandDisplayNameAnnotation: "gh sync",
+		},
+		Use:           "sync [<branch>...]",
+		Args:          cobra.ArbitraryArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			base, err := loadConfig(cmd.Context())
+			if err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			opts = resolveOptions(cmd.Flags(), base, flags)
+			*errOut = newTheme(opts.color, stderr)
+			if err := validateOptions(opts); err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+
+			git.Stderr = stderr
+			if err := setupLogging(stderr, opts); err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := checkBranchArgs(cmd.Context(), args); err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			opts.include = append(opts.include, args...)
+
+			*res, err = syncAll(cmd.Context(), stdout, stderr, opts)
+			return err
+		},
+	}
+	root.CompletionOptions.DisableDefaultCmd = true
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &exitError{code: exitUsage, err: err}
+	})
+
+	fs := root.PersistentFlags()
+	fs.BoolVarP(&flags.verbose, "verbose", "v", false, "Log each git command, its errors and each branch decision to stderr")
+	fs.StringVar(&flags.logFile, "log-file", "", "Write a JSON trace of git commands and branch decisions to `file`")
+	fs.BoolVar(&flags.timings, "timings", false, "Print how long each kind of git command took in total to stderr")
+	fs.BoolVar(&flags.recurseSubmodules, "recurse-submodules", false, "Also sync every initialized submodule")
+	fs.StringSliceVar(&flags.remotes, "remote", nil, "Preferred `remotes`, most preferred first")
+	fs.StringArrayVar(&flags.protect, "protect", nil, "Never update or delete branches matching `glob` (repeatable)")
+	fs.StringArrayVarP(&flags.include, "branch", "b", nil, "Only process branches matching `glob` (repeatable)")
+	fs.StringArrayVarP(&flags.exclude, "exclude", "x", nil, "Skip branches matching `glob` (repeatable)")
+	fs.StringVar(&flags.deletePolicy, "delete", "", "Deletion `policy`: merged, strict or never")
+	fs.StringVar(&flags.format, "format", "", "Output `format`: text or json")
+	fs.StringVar(&flags.color, "color", "", "Color `mode`: always, never or auto (default)")
+	fs.DurationVar(&flags.fetchTimeout, "fetch-timeout", 0, "Give up on a fetch attempt after `duration` (0 for no limit)")
+	fs.IntVar(&flags.fetchRetries, "fetch-retries", 0, "Retry a failed fetch up to `n` times")
+
+	// --no-fetch only makes sense for a sync, so it isn't persistent; status
+	// has --fetch instead.
+	root.Flags().BoolVar(&flags.noFetch, "no-fetch", false, "Use the existing remote-tracking refs without fetching")
+
+	runCmd := &cobra.Command{
+		Use:   "run [<branch>...]",
+		Short: "Fetch, fast-forward and clean up local branches",
+		Long:  longHelp + "\n\n" + branchArgsHelp,
+		Args:  cobra.ArbitraryArgs,
+		RunE:  root.RunE,
+	}
+
+	runCmd.Flags().AddFlag(root.Flags().Lookup("no-fetch"))
+
+	undoCmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Restore the branches changed by the last run",
+		Long: `Restore the branches changed by the last run.
+
+Fast-forwarded branches are moved back and deleted branches are recreated
+at their old commits. Nothing is restored if any of those branches has
+changed since.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			*res, err = undo(cmd.Context(), stdout, stderr, opts)
+			return err
+		},
+	}
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show the effective configuration",
+		Long:  "Show the effective configuration.\n\n" + configHelp,
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printConfig(stdout, opts)
+			return nil
+		},
+	}
+
+	var fetchFirst bool
+	statusCmd := &cobra.Command{
+		Use:   "status [<branch>...]",
+		Short: "Show how local branches compare with the remote",
+		Long: `Show how each local branch compares with the primary remote: up to date,
+behind, ahead, diverged, gone (merged or not) or untracked. Nothing is
+fetched or changed unless --fetch is given, which fetches first.
+
+The exit code is 3 when a branch has unpushed or unmerged work.
+
+` + branchArgsHelp,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := checkBranchArgs(cmd.Context(), args); err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			opts.include = append(opts.include, args...)
+
+			*res, err = status(cmd.Context(), stdout, stderr, opts, fetchFirst)
+			return err
+		},
+	}
+	statusCmd.Flags().BoolVar(&fetchFirst, "fetch", false, "Fetch from the remote before comparing")
+
+	var olderThan string
+	var prune bool
+	staleCmd := &cobra.Command{
+		Use:   "stale",
+		Short: "List branches with no commits for a while",
+		Long: `List local branches whose last commit is older than --older-than, along
+with their upstream status. Nothing is fetched.
+
+With --prune, stale branches that are merged or squash-merged into the
+default branch are deleted, following --delete. The default branch,
+protected branches, checked-out branches and unmerged branches are always
+kept.`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			*res, err = stale(cmd.Context(), stdout, stderr, opts, age, prune)
+			return err
+		},
+	}
+	staleCmd.Flags().StringVar(&olderThan, "older-than", "90d", "Minimum `age` of the last commit, e.g. 90d, 12w or 36h")
+	staleCmd.Flags().BoolVar(&prune, "prune", false, "Delete stale branches that are merged into the default branch")
+
+	explainCmd := &cobra.Command{
+		Use:   "explain <branch>",
+		Short: "Explain what sync would do with a branch, and why",
+		Long: `Walk through how sync decides what to do with a branch: which remote and
+default branch it uses, what the branch is compared with, the commits
+involved, the ancestry and squash-merge checks, and the resulting decision.
+Nothing is fetched or changed.`,
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return explain(cmd.Context(), stdout, opts, args[0])
+		},
+	}
+
+	root.AddCommand(runCmd, statusCmd, staleCmd, explainCmd, undoCmd, configCmd)
+	return root
+}
+
+// resolveOptions applies the flags that were set on the command line on top
+// of base.
+func resolveOptions(fs *pflag.FlagSet, base, flags options) options {
+	opts := base
+	if fs.Changed("verbose") {
+		opts.verbose = flags.verbose
+	}
+	if fs.Changed("log-file") {
+		opts.logFile = flags.logFile
+	}
+	if fs.Changed("timings") {
+		opts.timings = flags.timings
+	}
+	if fs.Changed("recurse-submodules") {
+		opts.recurseSubmodules = flags.recurseSubmodules
+	}
+	if fs.Changed("no-fetch") {
+		opts.noFetch = flags.noFetch
+	}
+	if fs.Changed("fetch-timeout") {
+		opts.fetchTimeout = flags.fetchTimeout
+	}
+	if fs.Changed("fetch-retries") {
+		opts.fetchRetries = flags.fetchRetries
+	}
+	if fs.Changed("remote") {
+		opts.remotes = flags.remotes
+	}
+	if fs.Changed("protect") {
+		// Protection only ever accumulates; a flag can't unprotect a branch
+		// that config protects.
+		opts.protect = append(slices.Clone(opts.protect), flags.protect...)
+	}
+	if fs.Changed("branch") {
+		opts.include = flags.include
+	}
+	if fs.Changed("exclude") {
+		opts.exclude = flags.exclude
+	}
+	if fs.Changed("delete") {
+		opts.deletePolicy = flags.deletePolicy
+	}
+	if fs.Changed("format") {
+		opts.format = flags.format
+	}
+	if fs.Changed("color") {
+		opts.color = flags.color
+	}
+	return opts
+}
+
+// checkBranchArgs makes sure every branch named on the command line matches
+// a local branch, so a mistyped branch or subcommand isn't silently ignored.
+func checkBranchArgs(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	branches, err := git.LocalBranches(ctx)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := path.Match(arg, ""); err != nil {
+			return fmt.Errorf("invalid branch pattern %q", arg)
+		}
+		if len(selectBranches(branches, []string{arg}, nil)) == 0 {
+			return fmt.Errorf("no local branch matches %q", arg)
+		}
+	}
+	return nil
+}
+
+// usageArgs marks positional argument errors as usage errors.
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return &exitError{code: exitUsage, err: err}
+		}
+		return nil
+	}
+}
+
+// printConfig writes the effective settings and where they come from.
+func printConfig(w io.Writer, opts options) {
+	file := configPath()
+	if _, err := os.Stat(file); err != nil {
+		file += " (not found)"
+	}
+
+	fmt.Fprintf(w, "file     %s\n", file)
+	if opts.repo != "" {
+		fmt.Fprintf(w, "repo     %s (GH_REPO)\n", opts.repo)
+	}
+	fmt.Fprintf(w, "remotes  %s\n", strings.Join(opts.remotes, ", "))
+	fmt.Fprintf(w, "protect  %s\n", strings.Join(opts.protect, ", "))
+	fmt.Fprintf(w, "delete   %s\n", opts.deletePolicy)
+	fmt.Fprintf(w, "format   %s\n", opts.format)
+	fmt.Fprintf(w, "color    %s\n", opts.color)
+	fmt.Fprintf(w, "verbose  %t\n", opts.verbose)
+	fmt.Fprintf(w, "prompt   %t\n", !opts.noPrompt)
+	fmt.Fprintf(w, "timeout  %s\n", opts.fetchTimeout)
+	fmt.Fprintf(w, "retries  %d\n", opts.fetchRetries)
+}
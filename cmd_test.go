@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// isolateConfig points the config file, global git config and gh config at
+// an empty temp dir so the user's own settings don't leak into a test.
+func isolateConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, "gitconfig"))
+	t.Setenv("GH_CONFIG_DIR", filepath.Join(dir, "gh"))
+	t.Setenv("GH_REPO", "")
+	t.Setenv("GH_PROMPT_DISABLED", "")
+	return dir
+}
+
+func runCmd(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var outBuf, errBuf bytes.Buffer
+	code = run(args, &outBuf, &errBuf)
+	return outBuf.String(), errBuf.String(), code
+}
+
+func TestRun_UsageErrors(t *testing.T) {
+	isolateConfig(t)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown flag", []string{"--unknown"}},
+		{"unknown command", []string{"frobnicate"}},
+		{"extra argument to run", []string{"run", "extra"}},
+		{"invalid delete policy", []string{"--delete=sometimes", "config"}},
+		{"invalid format", []string{"config", "--format", "xml"}},
+		{"invalid protect pattern", []string{"config", "--protect", "release/["}},
+		{"unmatched branch argument", []string{"no-such-branch"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runCmd(t, tt.args...)
+			if code != exitUsage {
+				t.Errorf("exit code = %d, want %d (stderr: %s)", code, exitUsage, stderr)
+			}
+			if !strings.HasPrefix(stderr, "error: ") {
+				t.Errorf("expected error message on stderr, got: %q", stderr)
+			}
+		})
+	}
+}
+
+func TestRun_Help(t *testing.T) {
+	for _, args := range [][]string{{"--help"}, {"-h"}, {"run", "--help"}, {"help", "undo"}} {
+		stdout, _, code := runCmd(t, args...)
+		if code != exitOK {
+			t.Errorf("run(%v) exit code = %d, want %d", args, code, exitOK)
+		}
+		if !strings.Contains(stdout, "gh sync") {
+			t.Errorf("run(%v) help missing command name, got: %s", args, stdout)
+		}
+	}
+
+	stdout, _, _ := runCmd(t, "--help")
+	for _, want := range []string{"--verbose", "--recurse-submodules", "--help", "Exit codes"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("help output missing %q", want)
+		}
+	}
+}
+
+func TestRun_ConfigMergesFlags(t *testing.T) {
+	env := newTestEnv(t)
+	env.chdir()
+	isolateConfig(t)
+
+	mustExec(t, env.local, "git", "config", "gh-sync.format", "json")
+	mustExec(t, env.local, "git", "config", "gh-sync.delete", "strict")
+
+	mustExec(t, env.local, "git", "config", "gh-sync.protect", "main")
+
+	stdout, stderr, code := runCmd(t, "config", "--delete=never", "--remote", "upstream,origin", "-v",
+		"--protect", "release/*", "--protect=prod", "--color=never")
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	for _, want := range []string{
+		"format   json",  // from git config
+		"delete   never", // flag overrides git config
+		"remotes  upstream, origin",
+		"protect  main, release/*, prod", // flags add to git config
+		"verbose  true",
+		"color    never",
+		"(not found)",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("config output missing %q, got:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestRun_BranchArguments(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdir()
+	isolateConfig(t)
+
+	// Combined short flags: -v and -x with its value.
+	stdout, stderr, code := runCmd(t, "run", "-vx", "main", "feat*")
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Updated branch feature") || strings.Contains(stdout, "Updated branch main") {
+		t.Errorf("expected only feature to be updated, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stderr, "$ git") {
+		t.Errorf("expected -v to enable verbose logging, got stderr: %s", stderr)
+	}
+}
+
+func TestRun_DefaultsToSync(t *testing.T) {
+	env := newTestEnv(t)
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.chdir()
+	isolateConfig(t)
+
+	stdout, stderr, code := runCmd(t)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Updated branch main") {
+		t.Errorf("expected update message, got stdout: %s", stdout)
+	}
+}
+
+func TestRun_NoFetch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("merged-feature", "merged.txt", "content\n")
+	env.mergeOnRemote("merged-feature")
+	env.deleteRemoteBranch("merged-feature")
+	mustExec(t, env.local, "git", "fetch", "--quiet", "origin")
+
+	// Simulate being offline.
+	mustExec(t, env.local, "git", "remote", "set-url", "origin", filepath.Join(env.base, "missing.git"))
+	env.chdir()
+	isolateConfig(t)
+
+	if _, stderr, code := runCmd(t); code != exitFetchFailed {
+		t.Fatalf("exit code = %d, want %d; stderr: %s", code, exitFetchFailed, stderr)
+	}
+
+	for _, args := range [][]string{{"--no-fetch"}, {"run", "--no-fetch"}} {
+		stdout, stderr, code := runCmd(t, args...)
+		if code != exitOK {
+			t.Fatalf("%v: exit code = %d, stderr: %s", args, code, stderr)
+		}
+		if args[0] == "--no-fetch" && !strings.Contains(stdout, "Deleted branch merged-feature") {
+			t.Errorf("expected merged branch to be deleted offline, got stdout: %s", stdout)
+		}
+	}
+}
+
+func TestRun_LogFile(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdir()
+	isolateConfig(t)
+
+	logPath := filepath.Join(t.TempDir(), "trace.json")
+	_, stderr, code := runCmd(t, "--log-file", logPath)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if strings.Contains(stderr, "$ git") {
+		t.Errorf("--log-file alone should not log to stderr, got: %s", stderr)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sawFetch, sawDecision bool
+	for line := range strings.Lines(string(data)) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		switch rec["msg"] {
+		case "git":
+			if args, _ := rec["args"].(string); strings.HasPrefix(args, "fetch ") {
+				sawFetch = rec["exit"] == 0.0 && rec["duration"] != nil
+			}
+		case "decision":
+			if rec["branch"] == "feature" {
+				sawDecision = rec["state"] == "behind" && rec["action"] == "update"
+			}
+		}
+	}
+	if !sawFetch {
+		t.Errorf("trace has no successful fetch with a duration:\n%s", data)
+	}
+	if !sawDecision {
+		t.Errorf("trace has no decision to update feature:\n%s", data)
+	}
+}
+
+func TestRun_StalePrune(t *testing.T) {
+	env := newTestEnv(t)
+	env.oldBranch("old-squashed", 200*24*time.Hour)
+	mustExec(t, env.local, "git", "push", "origin", "old-squashed")
+	env.squashMergeOnRemote("old-squashed")
+	mustExec(t, env.local, "git", "fetch", "--quiet", "origin")
+	env.chdir()
+	isolateConfig(t)
+
+	// --delete is still the policy, so strict keeps the squash-merged branch.
+	_, stderr, code := runCmd(t, "stale", "--prune", "--delete", "strict")
+	if code == exitUsage {
+		t.Fatalf("stale --prune --delete strict exit code = %d; stderr: %s", code, stderr)
+	}
+	if !git.HasRef(t.Context(), "refs/heads/old-squashed") {
+		t.Error("strict policy should keep the squash-merged branch")
+	}
+
+	stdout, stderr, code := runCmd(t, "stale", "--prune")
+	if code != exitOK {
+		t.Fatalf("stale --prune exit code = %d, want %d; stderr: %s", code, exitOK, stderr)
+	}
+	if !strings.Contains(stdout, "Deleted branch old-squashed") || git.HasRef(t.Context(), "refs/heads/old-squashed") {
+		t.Errorf("expected old-squashed to be deleted, got stdout: %s", stdout)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wassimk/gh-sync/internal/git"
+	"gopkg.in/yaml.v3"
+)
+
+// Deletion policies for branches whose upstream was deleted.
+const (
+	deleteMerged = "merged" // delete if merged or squash-merged into the default branch
+	deleteStrict = "strict" // delete only if merged without squashing
+	deleteNever  = "never"  // never delete
+)
+
+// Output formats.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// fileConfig is the shape of the optional config file.
+type fileConfig struct {
+	Remotes []string `yaml:"remotes"`
+	Protect []string `yaml:"protect"`
+	Delete  string   `yaml:"delete"`
+	Format  string   `yaml:"format"`
+	Color   string   `yaml:"color"`
+	Verbose *bool    `yaml:"verbose"`
+
+	FetchTimeout string `yaml:"fetchTimeout"`
+	FetchRetries *int   `yaml:"fetchRetries"`
+}
+
+func defaultOptions() options {
+	return options{
+		deletePolicy: deleteMerged,
+		format:       formatText,
+		color:        colorAuto,
+		fetchTimeout: 5 * time.Minute,
+		fetchRetries: 2,
+	}
+}
+
+// configPath returns where the optional config file lives, honouring
+// XDG_CONFIG_HOME.
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gh-sync", "config.yml")
+}
+
+// loadConfig builds the options a run starts from: built-in defaults, then
+// gh's own config and environment, then the config file, then gh-sync.*
+// keys from git config, so the more specific setting wins. Command-line
+// flags are applied on top by resolveOptions.
+func loadConfig(ctx context.Context) (options, error) {
+	opts := defaultOptions()
+	applyGhConfig(&opts)
+
+	if err := applyConfigFile(&opts, configPath()); err != nil {
+		return opts, err
+	}
+
+	entries, err := git.ConfigEntries(ctx, `^gh-sync\.`)
+	if err != nil {
+		return opts, err
+	}
+	if err := applyGitConfig(&opts, entries); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// applyConfigFile merges the YAML config file at path into opts. A missing
+// file is not an error.
+func applyConfigFile(opts *options, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var cfg fileConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if len(cfg.Remotes) > 0 {
+		opts.remotes = cfg.Remotes
+	}
+	opts.protect = append(opts.protect, cfg.Protect...)
+	if cfg.Delete != "" {
+		opts.deletePolicy = cfg.Delete
+	}
+	if cfg.Format != "" {
+		opts.format = cfg.Format
+	}
+	if cfg.Color != "" {
+		opts.color = cfg.Color
+	}
+	if cfg.Verbose != nil {
+		opts.verbose = *cfg.Verbose
+	}
+	if cfg.FetchTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.FetchTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: fetchTimeout: %w", path, err)
+		}
+		opts.fetchTimeout = timeout
+	}
+	if cfg.FetchRetries != nil {
+		opts.fetchRetries = *cfg.FetchRetries
+	}
+
+	if err := validateOptions(*opts); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyGitConfig merges gh-sync.* git config entries into opts, in the order
+// git reports them. Protected patterns accumulate across entries; every
+// other key is overridden by its last value.
+func applyGitConfig(opts *options, entries []git.ConfigEntry) error {
+	for _, e := range entries {
+		switch e.Key {
+		case "gh-sync.remotes":
+			opts.remotes = strings.FieldsFunc(e.Value, func(r rune) bool {
+				return r == ',' || r == ' '
+			})
+		case "gh-sync.protect":
+			opts.protect = append(opts.protect, e.Value)
+		case "gh-sync.delete":
+			opts.deletePolicy = e.Value
+		case "gh-sync.format":
+			opts.format = e.Value
+		case "gh-sync.color":
+			opts.color = e.Value
+		case "gh-sync.verbose":
+			verbose, err := parseGitBool(e.Value)
+			if err != nil {
+				return fmt.Errorf("invalid git config %s: %w", e.Key, err)
+			}
+			opts.verbose = verbose
+		case "gh-sync.fetchtimeout":
+			timeout, err := time.ParseDuration(e.Value)
+			if err != nil {
+				return fmt.Errorf("invalid git config %s: %w", e.Key, err)
+			}
+			opts.fetchTimeout = timeout
+		case "gh-sync.fetchretries":
+			retries, err := strconv.Atoi(e.Value)
+			if err != nil {
+				return fmt.Errorf("invalid git config %s: %w", e.Key, err)
+			}
+			opts.fetchRetries = retries
+		}
+	}
+
+	if err := validateOptions(*opts); err != nil {
+		return fmt.Errorf("invalid git config: %w", err)
+	}
+	return nil
+}
+
+// parseGitBool interprets a boolean the way git config does. A key with no
+// value at all counts as true.
+func parseGitBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "", "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean: %q", s)
+}
+
+func validateOptions(opts options) error {
+	switch opts.deletePolicy {
+	case deleteMerged, deleteStrict, deleteNever:
+	default:
+		return fmt.Errorf("unknown delete policy %q (want %s, %s or %s)",
+			opts.deletePolicy, deleteMerged, deleteStrict, deleteNever)
+	}
+
+	switch opts.format {
+	case formatText, formatJSON:
+	default:
+		return fmt.Errorf("unknown output format %q (want %s or %s)", opts.format, formatText, formatJSON)
+	}
+
+	switch opts.color {
+	case "", colorAuto, colorAlways, colorNever:
+	default:
+		return fmt.Errorf("unknown color mode %q (want %s, %s or %s)", opts.color, colorAuto, colorAlways, colorNever)
+	}
+
+	if opts.fetchTimeout < 0 {
+		return fmt.Errorf("fetch timeout must not be negative, got %s", opts.fetchTimeout)
+	}
+	if opts.fetchRetries < 0 {
+		return fmt.Errorf("fetch retries must not be negative, got %d", opts.fetchRetries)
+	}
+
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"protect", opts.protect}, {"branch", opts.include}, {"exclude", opts.exclude}} {
+		for _, pattern := range list.patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid %s pattern %q", list.name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether branch matches one of the glob patterns.
+func matchesAny(branch string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeTestFile(t, path, `remotes: [upstream, origin]
+protect:
+  - release/*
+  - develop
+delete: strict
+format: json
+color: always
+verbose: true
+fetchTimeout: 30s
+fetchRetries: 0
+`)
+
+	opts := defaultOptions()
+	if err := applyConfigFile(&opts, path); err != nil {
+		t.Fatalf("applyConfigFile() error: %v", err)
+	}
+
+	want := options{
+		verbose:      true,
+		remotes:      []string{"upstream", "origin"},
+		protect:      []string{"release/*", "develop"},
+		deletePolicy: deleteStrict,
+		format:       formatJSON,
+		color:        colorAlways,
+		fetchTimeout: 30 * time.Second,
+		fetchRetries: 0,
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("options = %+v, want %+v", opts, want)
+	}
+}
+
+func TestApplyConfigFile_Missing(t *testing.T) {
+	opts := defaultOptions()
+	if err := applyConfigFile(&opts, filepath.Join(t.TempDir(), "nope.yml")); err != nil {
+		t.Fatalf("applyConfigFile() error for missing file: %v", err)
+	}
+	if !reflect.DeepEqual(opts, defaultOptions()) {
+		t.Errorf("options = %+v, want defaults", opts)
+	}
+}
+
+func TestApplyConfigFile_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":    "remote: origin\n",
+		"bad policy":     "delete: sometimes\n",
+		"bad format":     "format: xml\n",
+		"bad color":      "color: sometimes\n",
+		"bad pattern":    "protect: ['release/[']\n",
+		"bad timeout":    "fetchTimeout: soon\n",
+		"bad retries":    "fetchRetries: -1\n",
+		"malformed yaml": "protect: [\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			writeTestFile(t, path, content)
+
+			opts := defaultOptions()
+			if err := applyConfigFile(&opts, path); err == nil {
+				t.Errorf("applyConfigFile(%q) succeeded, want error", content)
+			}
+		})
+	}
+}
+
+func TestApplyGitConfig(t *testing.T) {
+	opts := defaultOptions()
+	opts.protect = []string{"main"}
+
+	err := applyGitConfig(&opts, []git.ConfigEntry{
+		{Key: "gh-sync.remotes", Value: "github"},
+		{Key: "gh-sync.remotes", Value: "upstream, origin"},
+		{Key: "gh-sync.protect", Value: "release/*"},
+		{Key: "gh-sync.delete", Value: "never"},
+		{Key: "gh-sync.verbose", Value: "yes"},
+		{Key: "gh-sync.color", Value: "never"},
+		{Key: "gh-sync.fetchtimeout", Value: "1m30s"},
+		{Key: "gh-sync.fetchretries", Value: "5"},
+		{Key: "gh-sync.unknown", Value: "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("applyGitConfig() error: %v", err)
+	}
+
+	want := options{
+		verbose:      true,
+		remotes:      []string{"upstream", "origin"},
+		protect:      []string{"main", "release/*"},
+		deletePolicy: deleteNever,
+		format:       formatText,
+		color:        colorNever,
+		fetchTimeout: 90 * time.Second,
+		fetchRetries: 5,
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("options = %+v, want %+v", opts, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	env := newTestEnv(t)
+	env.chdir()
+
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(xdg, "gitconfig"))
+	writeTestFile(t, filepath.Join(xdg, "gh-sync", "config.yml"), "format: json\ndelete: strict\n")
+
+	// Repository git config overrides the config file.
+	mustExec(t, env.local, "git", "config", "gh-sync.delete", "never")
+	mustExec(t, env.local, "git", "config", "--add", "gh-sync.protect", "release/*")
+
+	opts, err := loadConfig(t.Context())
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	if opts.format != formatJSON {
+		t.Errorf("format = %q, want %q", opts.format, formatJSON)
+	}
+	if opts.deletePolicy != deleteNever {
+		t.Errorf("deletePolicy = %q, want %q", opts.deletePolicy, deleteNever)
+	}
+	if !reflect.DeepEqual(opts.protect, []string{"release/*"}) {
+		t.Errorf("protect = %v, want [release/*]", opts.protect)
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	patterns := []string{"main", "release/*"}
+	tests := map[string]bool{
+		"main":          true,
+		"release/1.0":   true,
+		"release/1/fix": false,
+		"feature":       false,
+		"mainline":      false,
+	}
+
+	for branch, want := range tests {
+		if got := matchesAny(branch, patterns); got != want {
+			t.Errorf("matchesAny(%q) = %v, want %v", branch, got, want)
+		}
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	if got := configPath(); got != filepath.Join("/xdg", "gh-sync", "config.yml") {
+		t.Errorf("configPath() = %q", got)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	home, _ := os.UserHomeDir()
+	if got := configPath(); got != filepath.Join(home, ".config", "gh-sync", "config.yml") {
+		t.Errorf("configPath() = %q", got)
+	}
+}
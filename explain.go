@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+type traceKey struct{}
+
+// withTrace returns a context under which classifyBranch and the decisions
+// that follow describe each step they take to f.
+func withTrace(ctx context.Context, f func(step string)) context.Context {
+	return context.WithValue(ctx, traceKey{}, f)
+}
+
+// trace describes a step of deciding what to do with a branch, for gh sync
+// explain. It does nothing unless ctx came from withTrace.
+func trace(ctx context.Context, format string, args ...any) {
+	if f, ok := ctx.Value(traceKey{}).(func(string)); ok {
+		f(fmt.Sprintf(format, args...))
+	}
+}
+
+// explain prints every step sync would take to decide what to do with
+// branch, from choosing the remote to the final decision, without fetching
+// or changing anything.
+func explain(ctx context.Context, stdout io.Writer, opts options, branch string) error {
+	repo, err := git.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+	if !git.HasRef(ctx, "refs/heads/"+branch) {
+		return &exitError{code: exitUsage, err: fmt.Errorf("no local branch named %q", branch)}
+	}
+
+	remote, err := mainRemote(ctx, opts)
+	if err != nil {
+		return err
+	}
+	candidates := slices.Concat(opts.remotes, git.RemotePreference)
+	switch {
+	case opts.repo != "":
+		fmt.Fprintf(stdout, "Remote:          %s, which points at %s from GH_REPO\n", remote, opts.repo)
+	case remote == git.BaseRemote(ctx) && !slices.Contains(opts.remotes, remote):
+		fmt.Fprintf(stdout, "Remote:          %s, the base repository chosen with gh repo set-default\n", remote)
+	case slices.Contains(candidates, remote):
+		fmt.Fprintf(stdout, "Remote:          %s, the first of %s that exists\n", remote, strings.Join(candidates, ", "))
+	default:
+		fmt.Fprintf(stdout, "Remote:          %s, the first remote, since none of %s exist\n", remote, strings.Join(candidates, ", "))
+	}
+
+	defaultBranch, ok := git.DefaultBranch(ctx, remote)
+	defaultRef := fmt.Sprintf("refs/remotes/%s/%s", remote, defaultBranch)
+	if ok {
+		fmt.Fprintf(stdout, "Default branch:  %s\n", defaultBranch)
+	} else {
+		fmt.Fprintf(stdout, "Default branch:  %s, a guess, since refs/remotes/%s/HEAD is missing\n", defaultBranch, remote)
+	}
+
+	currentBranch, _ := git.CurrentBranch(ctx)
+	if !repo.WorkTree {
+		currentBranch = ""
+	}
+	if currentBranch != "" {
+		fmt.Fprintf(stdout, "Current branch:  %s\n", currentBranch)
+	}
+	fmt.Fprintf(stdout, "Delete policy:   %s\n", opts.deletePolicy)
+
+	fmt.Fprintf(stdout, "\n%s\n", branch)
+	ctx = withTrace(ctx, func(step string) {
+		fmt.Fprintf(stdout, "  %s\n", step)
+	})
+
+	if len(selectBranches([]string{branch}, opts.include, opts.exclude)) == 0 {
+		trace(ctx, "it doesn't match --branch or matches --exclude, so sync leaves it alone")
+	}
+
+	pc := &planContext{
+		remote:        remote,
+		defaultBranch: defaultBranch,
+		currentBranch: currentBranch,
+		opts:          opts,
+	}
+	if err := pc.checkedOut(ctx, repo); err != nil {
+		return err
+	}
+
+	st, err := classifyBranch(ctx, branch, remote, defaultRef, git.BranchRemotes(ctx), opts.deletePolicy)
+	if err != nil {
+		return err
+	}
+	p := planBranch(ctx, branch, st, pc)
+
+	fmt.Fprintf(stdout, "\nState:           %s\n", st.state)
+	decision := p.action
+	if p.reason != "" {
+		decision += ": " + p.reason
+	}
+	fmt.Fprintf(stdout, "Decision:        %s\n", decision)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExplain_SquashMerged(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("squashed", "squashed.txt", "squashed\n")
+	env.squashMergeOnRemote("squashed")
+	env.deleteRemoteBranch("squashed")
+	mustExec(t, env.local, "git", "fetch", "--prune", "origin")
+	env.chdir()
+
+	var stdout bytes.Buffer
+	if err := explain(t.Context(), &stdout, defaultOptions(), "squashed"); err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+
+	out := stdout.String()
+	for _, want := range []string{
+		"Remote:          origin, the first of upstream, github, origin that exists",
+		"Default branch:  main",
+		"branch.squashed.remote is origin, so it is compared with its upstream",
+		"its upstream no longer exists",
+		"refs/heads/squashed is not an ancestor of refs/remotes/origin/main",
+		"merge-base with refs/remotes/origin/main is",
+		"so it was squash-merged",
+		"State:           gone, merged",
+		"Decision:        delete",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explain output missing %q, got:\n%s", want, out)
+		}
+	}
+
+	// Explaining must not delete anything.
+	mustExec(t, env.local, "git", "rev-parse", "--verify", "refs/heads/squashed")
+}
+
+func TestExplain_MatchedByName(t *testing.T) {
+	env := newTestEnv(t)
+	mustExec(t, env.local, "git", "checkout", "-b", "implicit")
+	mustExec(t, env.local, "git", "push", "origin", "implicit")
+	mustExec(t, env.local, "git", "checkout", "main")
+	env.addRemoteCommit("implicit", "implicit.txt", "remote\n")
+	mustExec(t, env.local, "git", "fetch", "origin")
+	env.chdir()
+
+	var stdout bytes.Buffer
+	if err := explain(t.Context(), &stdout, defaultOptions(), "implicit"); err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+
+	out := stdout.String()
+	for _, want := range []string{
+		"branch.implicit.remote is not set, so it is compared with refs/remotes/origin/implicit by name",
+		"refs/heads/implicit is an ancestor of refs/remotes/origin/implicit",
+		"State:           behind",
+		"Decision:        update",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explain output missing %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestExplain_UnknownBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.chdir()
+
+	err := explain(t.Context(), &bytes.Buffer{}, defaultOptions(), "nope")
+	if code := exitCode(result{}, err); code != exitUsage {
+		t.Errorf("exitCode() = %d, want %d (err: %v)", code, exitUsage, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+	"github.com/cli/go-gh/v2/pkg/auth"
+	"github.com/cli/go-gh/v2/pkg/config"
+	"github.com/cli/go-gh/v2/pkg/repository"
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// errNoRepoRemote reports that GH_REPO names a repository none of the
+// remotes point at.
+var errNoRepoRemote = errors.New("no remote points at the repository in GH_REPO")
+
+// applyGhConfig applies the parts of gh's own configuration and environment
+// that gh-sync follows, so it behaves like the rest of gh:
+//
+//   - GH_REPO names the repository to sync, as [HOST/]OWNER/REPO or a URL;
+//     GH_HOST or gh's only logged-in host fills in a missing host.
+//   - GH_PROMPT_DISABLED, or "gh config set prompt disabled", means gh-sync
+//     must never ask questions.
+//
+// A missing or unreadable gh config is ignored, as gh itself does.
+func applyGhConfig(opts *options) {
+	opts.repo = os.Getenv("GH_REPO")
+
+	if os.Getenv("GH_PROMPT_DISABLED") != "" {
+		opts.noPrompt = true
+	} else if cfg, err := config.Read(nil); err == nil {
+		if prompt, err := cfg.Get([]string{"prompt"}); err == nil && prompt == "disabled" {
+			opts.noPrompt = true
+		}
+	}
+}
+
+// mainRemote picks the remote to sync with. When GH_REPO names the
+// repository, that is the remote pointing at it, preferring the usual order
+// if several do; otherwise git.MainRemote chooses.
+func mainRemote(ctx context.Context, opts options) (string, error) {
+	if opts.repo == "" {
+		return git.MainRemote(ctx, opts.remotes...)
+	}
+
+	want, err := repository.Parse(opts.repo)
+	if err != nil {
+		return "", &exitError{code: exitUsage, err: fmt.Errorf("invalid GH_REPO: %w", err)}
+	}
+
+	urls := git.RemoteURLs(ctx)
+	names := slices.Concat(opts.remotes, []string{git.BaseRemote(ctx)}, git.RemotePreference, slices.Sorted(maps.Keys(urls)))
+	for _, name := range names {
+		url, ok := urls[name]
+		if !ok {
+			continue
+		}
+		if got, err := repository.Parse(url); err == nil && sameRepository(got, want) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w (%s/%s/%s)", errNoRepoRemote, want.Host, want.Owner, want.Name)
+}
+
+// sameRepository reports whether a and b are the same GitHub repository.
+// Owner and repository names are case-insensitive, and hosts are compared
+// the way gh compares them.
+func sameRepository(a, b repository.Repository) bool {
+	return auth.NormalizeHostname(a.Host) == auth.NormalizeHostname(b.Host) &&
+		strings.EqualFold(a.Owner, b.Owner) &&
+		strings.EqualFold(a.Name, b.Name)
+}
+
+// resolveDefaultBranch works out the default branch of remote. When
+// refs/remotes/<remote>/HEAD is missing and ask is set, it asks the remote,
+// then GitHub, and records the answer in that ref so later runs needn't ask
+// again. Commands that promise not to fetch or change anything don't ask.
+// Otherwise, or if neither knows, it warns that the branch is a guess: a
+// wrong default branch makes every merged check wrong.
+func resolveDefaultBranch(ctx context.Context, stderr io.Writer, opts options, remote string, ask bool) string {
+	branch, ok := git.DefaultBranch(ctx, remote)
+	if ok {
+		return branch
+	}
+
+	if ask {
+		askCtx := ctx
+		if opts.fetchTimeout > 0 {
+			var cancel context.CancelFunc
+			askCtx, cancel = context.WithTimeout(ctx, opts.fetchTimeout)
+			defer cancel()
+		}
+
+		name, err := git.RemoteDefaultBranch(askCtx, remote)
+		if err != nil {
+			logger.DebugContext(ctx, "default branch", "remote", remote, "error", err)
+			name, err = githubDefaultBranch(askCtx, remote)
+		}
+		if err == nil {
+			if git.HasRef(ctx, fmt.Sprintf("refs/remotes/%s/%s", remote, name)) {
+				if err := git.SetDefaultBranch(ctx, remote, name); err != nil {
+					logger.DebugContext(ctx, "default branch", "remote", remote, "error", err)
+				}
+			}
+			return name
+		}
+		logger.DebugContext(ctx, "default branch", "remote", remote, "error", err)
+	}
+
+	newTheme(opts.color, stderr).warnf(stderr,
+		"could not confirm the default branch of %s; assuming '%s' (set it with 'git remote set-head %s <branch>')",
+		remote, branch, remote)
+	return branch
+}
+
+// githubDefaultBranch asks the GitHub API for the default branch of the
+// repository remote points at.
+func githubDefaultBranch(ctx context.Context, remote string) (string, error) {
+	repo, err := repository.Parse(git.RemoteURLs(ctx)[remote])
+	if err != nil {
+		return "", fmt.Errorf("%s is not a GitHub repository: %w", remote, err)
+	}
+
+	client, err := api.NewRESTClient(api.ClientOptions{Host: repo.Host})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	path := fmt.Sprintf("repos/%s/%s", repo.Owner, repo.Name)
+	if err := client.DoWithContext(ctx, "GET", path, nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.DefaultBranch == "" {
+		return "", fmt.Errorf("GitHub did not say which branch is the default of %s/%s", repo.Owner, repo.Name)
+	}
+	return resp.DefaultBranch, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"testing"
+
+	"github.com/cli/go-gh/v2/pkg/config"
+)
+
+func TestApplyGhConfig(t *testing.T) {
+	read := config.Read
+	t.Cleanup(func() { config.Read = read })
+
+	tests := []struct {
+		name     string
+		env      string
+		config   string
+		noPrompt bool
+	}{
+		{"prompts by default", "", "", false},
+		{"GH_PROMPT_DISABLED", "1", "", true},
+		{"gh config prompt disabled", "", "prompt: disabled\n", true},
+		{"gh config prompt enabled", "", "prompt: enabled\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GH_PROMPT_DISABLED", tt.env)
+			t.Setenv("GH_REPO", "acme/widget")
+			config.Read = func(*config.Config) (*config.Config, error) {
+				return config.ReadFromString(tt.config), nil
+			}
+
+			opts := defaultOptions()
+			applyGhConfig(&opts)
+			if opts.noPrompt != tt.noPrompt {
+				t.Errorf("noPrompt = %t, want %t", opts.noPrompt, tt.noPrompt)
+			}
+			if opts.repo != "acme/widget" {
+				t.Errorf("repo = %q, want acme/widget from GH_REPO", opts.repo)
+			}
+		})
+	}
+}
+
+func TestMainRemote_GHRepo(t *testing.T) {
+	env := newTestEnv(t)
+	mustExec(t, env.local, "git", "remote", "set-url", "origin", "https://github.com/me/widget.git")
+	mustExec(t, env.local, "git", "remote", "add", "upstream", "git@github.com:Acme/Widget.git")
+	mustExec(t, env.local, "git", "remote", "add", "work", "https://ghe.example.com/acme/widget")
+	env.chdir()
+
+	tests := []struct {
+		repo, host string
+		want       string
+	}{
+		{"", "", "upstream"},
+		{"me/widget", "", "origin"},
+		{"acme/widget", "", "upstream"},
+		{"acme/widget", "ghe.example.com", "work"},
+		{"ghe.example.com/acme/widget", "", "work"},
+		{"https://github.com/me/widget", "", "origin"},
+	}
+	for _, tt := range tests {
+		t.Setenv("GH_HOST", cmp.Or(tt.host, "github.com"))
+		got, err := mainRemote(t.Context(), options{repo: tt.repo})
+		if err != nil || got != tt.want {
+			t.Errorf("mainRemote(GH_REPO=%q, GH_HOST=%q) = %q, %v; want %q", tt.repo, tt.host, got, err, tt.want)
+		}
+	}
+
+	_, err := mainRemote(t.Context(), options{repo: "acme/gadget"})
+	if !errors.Is(err, errNoRepoRemote) || exitCode(result{}, err) != exitNoRepo {
+		t.Errorf("mainRemote() for a repository with no remote = %v, want errNoRepoRemote", err)
+	}
+
+	_, err = mainRemote(t.Context(), options{repo: "not a repo"})
+	if exitCode(result{}, err) != exitUsage {
+		t.Errorf("mainRemote() for an invalid GH_REPO = %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,135 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Errors that callers can test for with errors.Is. They are recognised from
+// what git prints, so a failed command's *Error matches whichever applies.
+var (
+	ErrNotARepository = errors.New("not a git repository")
+	ErrNoRemotes      = errors.New("no git remotes found")
+	ErrRefLocked      = errors.New("ref is locked by another git process")
+	ErrDirtyWorktree  = errors.New("worktree has local changes that would be overwritten")
+	ErrNonFastForward = errors.New("not a fast-forward")
+	ErrAuthFailed     = errors.New("authentication failed")
+)
+
+// Error describes a git command that failed, with what it printed to stderr.
+type Error struct {
+	Args     []string // arguments after "git"
+	ExitCode int      // -1 if git was killed or never started
+	Stderr   string   // everything git wrote to stderr, trimmed
+	Err      error    // the underlying error from os/exec
+
+	kind error // one of the Err* sentinels, or nil
+}
+
+// newError wraps the failure of a git command run with args.
+func newError(args []string, err error, stderr string) *Error {
+	e := &Error{Args: args, ExitCode: -1, Stderr: strings.TrimSpace(stderr), Err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	if e.ExitCode > 0 {
+		e.kind = classify(e.Stderr)
+	}
+	return e
+}
+
+// Is reports whether the failure is the kind of error target describes.
+func (e *Error) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+func (e *Error) Error() string {
+	msg := "git " + formatArgs(e.Args)
+	if e.ExitCode >= 0 {
+		msg += fmt.Sprintf(" exited with status %d", e.ExitCode)
+	} else {
+		msg += fmt.Sprintf(" failed: %s", e.Err)
+	}
+	if detail := gitMessage(e.Stderr); detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// classify recognises common failures from git's stderr.
+func classify(stderr string) error {
+	s := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(s, "not a git repository"):
+		return ErrNotARepository
+	case strings.Contains(s, ".lock': file exists"), strings.Contains(s, "another git process seems to be running"):
+		return ErrRefLocked
+	case strings.Contains(s, "would be overwritten by"), strings.Contains(s, "please commit your changes or stash them"):
+		return ErrDirtyWorktree
+	case strings.Contains(s, "not possible to fast-forward"), strings.Contains(s, "diverging branches can't be fast-forwarded"):
+		return ErrNonFastForward
+	case containsAny(s, authFailures...):
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// authFailures are what git and its transports print when credentials are
+// missing or rejected, lowercased.
+var authFailures = []string{
+	"authentication failed", "permission denied (publickey", "could not read username",
+	"could not read password", "terminal prompts disabled", "invalid username or password",
+	"the requested url returned error: 401", "the requested url returned error: 403",
+}
+
+func containsAny(s string, needles ...string) bool {
+	for _, n := range needles {
+		if strings.Contains(s, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatArgs joins git arguments for display, quoting any that would
+// otherwise be ambiguous, such as a reflog message with spaces.
+func formatArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// gitMessage picks the most useful line out of git's stderr: the last
+// "fatal:" or "error:" line, or failing that the last line of any kind.
+// Progress updates are redrawn with carriage returns, so those split lines
+// too.
+func gitMessage(stderr string) string {
+	var last, message string
+	for _, line := range strings.FieldsFunc(stderr, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		last = line
+		for _, prefix := range []string{"fatal: ", "error: "} {
+			if rest, ok := strings.CutPrefix(line, prefix); ok {
+				message = rest
+			}
+		}
+	}
+	if message == "" {
+		return last
+	}
+	return message
+}
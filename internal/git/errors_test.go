@@ -0,0 +1,153 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	_, err := MergeBase(t.Context(), "main", "no-such-branch")
+	var gitErr *Error
+	if !errors.As(err, &gitErr) {
+		t.Fatalf("MergeBase() error = %v, want *Error", err)
+	}
+	if gitErr.ExitCode != 128 {
+		t.Errorf("ExitCode = %d, want 128", gitErr.ExitCode)
+	}
+	if !strings.Contains(gitErr.Stderr, "no-such-branch") {
+		t.Errorf("Stderr = %q, want it to name the bad ref", gitErr.Stderr)
+	}
+	want := "git merge-base main no-such-branch exited with status 128: Not a valid object name no-such-branch"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error
+	}{
+		{"fatal: not a git repository (or any of the parent directories): .git", ErrNotARepository},
+		{"fatal: Unable to create '/r/.git/refs/heads/x.lock': File exists.\n\nAnother git process seems to be running", ErrRefLocked},
+		{"error: Your local changes to the following files would be overwritten by merge:\n\ta.txt\nPlease commit your changes or stash them before you merge.", ErrDirtyWorktree},
+		{"fatal: Not possible to fast-forward, aborting.", ErrNonFastForward},
+		{"git@github.com: Permission denied (publickey).", ErrAuthFailed},
+		{"fatal: Not a valid object name nope", nil},
+	}
+
+	for _, tt := range tests {
+		if got := classify(tt.stderr); got != tt.want {
+			t.Errorf("classify(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestMergeFFOnly_DirtyWorktree(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	// Commit a change to README.md on another branch, then edit it locally so
+	// fast-forwarding to that branch would overwrite the edit.
+	mustGit(t, dir, "checkout", "-q", "-b", "ahead")
+	writeFile(t, filepath.Join(dir, "README.md"), "# committed\n")
+	mustGit(t, dir, "commit", "-qam", "change readme")
+	mustGit(t, dir, "checkout", "-q", "main")
+	writeFile(t, filepath.Join(dir, "README.md"), "# local edit\n")
+
+	err := MergeFFOnly(t.Context(), "ahead")
+	if !errors.Is(err, ErrDirtyWorktree) {
+		t.Fatalf("MergeFFOnly() error = %v, want ErrDirtyWorktree", err)
+	}
+	if errors.Is(err, ErrNonFastForward) {
+		t.Error("error should match only one sentinel")
+	}
+}
+
+func TestLogCommand(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	var buf bytes.Buffer
+	Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	RevParse(t.Context(), "HEAD")
+	MergeBase(t.Context(), "main", "no-such-branch")
+
+	var records []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(records), buf.String())
+	}
+
+	ok, failed := records[0], records[1]
+	if ok["msg"] != "git" || ok["args"] != "rev-parse --quiet HEAD" || ok["subcommand"] != "rev-parse" || ok["exit"] != 0.0 {
+		t.Errorf("successful command logged as %v", ok)
+	}
+	if _, has := ok["duration"]; !has {
+		t.Errorf("record has no duration: %v", ok)
+	}
+	if failed["args"] != "merge-base main no-such-branch" || failed["exit"] != 128.0 {
+		t.Errorf("failed command logged as %v", failed)
+	}
+	if stderr, _ := failed["stderr"].(string); !strings.Contains(stderr, "fatal: Not a valid object name") {
+		t.Errorf("failed command stderr = %q", stderr)
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"rev-parse", "--quiet", "HEAD"}, "rev-parse"},
+		{[]string{"-C", "/src/wt", "merge", "--ff-only", "main"}, "merge"},
+		{[]string{"--git-dir", "/src/repo.git", "symbolic-ref", "HEAD"}, "symbolic-ref"},
+		{[]string{"-c", "core.quotepath=off", "--no-pager", "log"}, "log"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := subcommand(tt.args); got != tt.want {
+			t.Errorf("subcommand(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFormatArgs(t *testing.T) {
+	got := formatArgs([]string{"update-ref", "--stdin", "-m", "gh-sync: fast-forward", ""})
+	want := `update-ref --stdin -m "gh-sync: fast-forward" ""`
+	if got != want {
+		t.Errorf("formatArgs() = %s, want %s", got, want)
+	}
+}
+
+func TestGitMessage(t *testing.T) {
+	tests := []struct {
+		stderr, want string
+	}{
+		{"remote: Counting objects: 10%\rremote: Counting objects: 100%\nfatal: the remote end hung up\n", "the remote end hung up"},
+		{"error: first\nfatal: second\nhint: try again\n", "second"},
+		{"ssh: Could not resolve hostname nope\n", "ssh: Could not resolve hostname nope"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := gitMessage(tt.stderr); got != tt.want {
+			t.Errorf("gitMessage(%q) = %q, want %q", tt.stderr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// FetchOptions controls how Fetch copes with slow or unreliable remotes.
+type FetchOptions struct {
+	Timeout time.Duration // limit for each attempt; zero means none
+	Retries int           // further attempts after a timeout or network failure
+	Backoff time.Duration // wait before the first retry, doubled for each one after
+}
+
+// FetchFailure says why a fetch failed.
+type FetchFailure int
+
+const (
+	FetchFailed        FetchFailure = iota // git failed for a reason we don't recognise
+	FetchTimedOut                          // the attempt ran past FetchOptions.Timeout
+	FetchNetwork                           // the remote host could not be reached
+	FetchAuth                              // the remote rejected our credentials
+	FetchRemoteMissing                     // the remote or its repository doesn't exist
+)
+
+// transient reports whether trying again might help.
+func (f FetchFailure) transient() bool {
+	return f == FetchTimedOut || f == FetchNetwork
+}
+
+// FetchError describes a failed fetch.
+type FetchError struct {
+	Remote   string
+	Failure  FetchFailure
+	Attempts int
+	Timeout  time.Duration
+	Stderr   string // everything git wrote to stderr on the last attempt
+	Err      error
+}
+
+func (e *FetchError) Error() string {
+	var msg string
+	switch e.Failure {
+	case FetchTimedOut:
+		msg = fmt.Sprintf("%s did not respond within %s", e.Remote, e.Timeout)
+	case FetchNetwork:
+		msg = fmt.Sprintf("could not reach %s", e.Remote)
+	case FetchAuth:
+		msg = fmt.Sprintf("%s rejected the credentials (check them with gh auth status)", e.Remote)
+	case FetchRemoteMissing:
+		msg = fmt.Sprintf("the repository for %s was not found", e.Remote)
+	default:
+		msg = e.Remote
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+
+	if e.Failure == FetchTimedOut {
+		return msg
+	}
+	if detail := gitMessage(e.Stderr); detail != "" {
+		return msg + ": " + detail
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// Fetch fetches from a remote with pruning and progress output. Timeouts
+// and network failures are retried with exponential backoff. If ctx is
+// cancelled the fetch stops and ctx's error is returned; any other error is
+// a *FetchError.
+func Fetch(ctx context.Context, remote string, opts FetchOptions) error {
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fetchOnce(ctx, remote, opts.Timeout)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err.Attempts = attempt
+
+		if attempt > opts.Retries || !err.Failure.transient() {
+			return err
+		}
+		fmt.Fprintf(Stderr, "fetch failed: %s; retrying in %s\n", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// fetchOnce makes a single fetch attempt, passing git's output through
+// while keeping a copy of stderr to explain a failure.
+func fetchOnce(ctx context.Context, remote string, timeout time.Duration) *FetchError {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	args := []string{"fetch", "--prune", "--quiet", "--progress", remote}
+
+	var stderr bytes.Buffer
+	cmd := command(ctx, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(Stderr, &stderr)
+	// Transport helpers like ssh can outlive a killed git and hold stderr
+	// open; don't wait on them forever.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	if err == nil {
+		logCommand(ctx, args, start, nil)
+		return nil
+	}
+
+	gitErr := newError(args, err, stderr.String())
+	logCommand(ctx, args, start, gitErr)
+
+	fe := &FetchError{Remote: remote, Timeout: timeout, Stderr: stderr.String(), Err: gitErr}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fe.Failure = FetchTimedOut
+		fe.Err = ctx.Err()
+	} else {
+		fe.Failure = classifyFetchFailure(fe.Stderr)
+	}
+	return fe
+}
+
+// classifyFetchFailure recognises the common reasons for a failed fetch from
+// what git printed. Authentication is checked first because ssh reports a
+// rejected key alongside the generic "could not read from remote".
+func classifyFetchFailure(stderr string) FetchFailure {
+	s := strings.ToLower(stderr)
+	switch {
+	case containsAny(s, authFailures...):
+		return FetchAuth
+	case containsAny(s, "repository not found", "does not appear to be a git repository",
+		"no such remote", "the requested url returned error: 404"):
+		return FetchRemoteMissing
+	case containsAny(s, "could not resolve host", "could not resolve hostname", "connection timed out",
+		"connection refused", "connection reset", "network is unreachable", "operation timed out",
+		"unable to access", "the remote end hung up unexpectedly", "early eof",
+		"could not read from remote repository", "ssl certificate", "ssl_connect", "gnutls"):
+		return FetchNetwork
+	}
+	return FetchFailed
+}
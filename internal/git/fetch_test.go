@@ -0,0 +1,116 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyFetchFailure(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   FetchFailure
+	}{
+		{"fatal: unable to access 'https://github.com/o/r/': Could not resolve host: github.com", FetchNetwork},
+		{"ssh: connect to host github.com port 22: Connection refused\nfatal: Could not read from remote repository.", FetchNetwork},
+		{"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", FetchAuth},
+		{"remote: Invalid username or password.\nfatal: Authentication failed for 'https://github.com/o/r/'", FetchAuth},
+		{"fatal: could not read Username for 'https://github.com': terminal prompts disabled", FetchAuth},
+		{"ERROR: Repository not found.\nfatal: Could not read from remote repository.", FetchRemoteMissing},
+		{"fatal: '/tmp/missing.git' does not appear to be a git repository", FetchRemoteMissing},
+		{"fatal: something else entirely", FetchFailed},
+		{"", FetchFailed},
+	}
+
+	for _, tt := range tests {
+		if got := classifyFetchFailure(tt.stderr); got != tt.want {
+			t.Errorf("classifyFetchFailure(%q) = %d, want %d", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestFetch_MissingRemoteNotRetried(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+	mustGit(t, dir, "remote", "set-url", "origin", filepath.Join(t.TempDir(), "missing.git"))
+
+	var stderr bytes.Buffer
+	Stderr = &stderr
+
+	err := Fetch(t.Context(), "origin", FetchOptions{Retries: 3, Backoff: time.Millisecond})
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Fetch() error = %v, want *FetchError", err)
+	}
+	if fe.Failure != FetchRemoteMissing {
+		t.Errorf("Failure = %d, want FetchRemoteMissing", fe.Failure)
+	}
+	if fe.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", fe.Attempts)
+	}
+	if !strings.Contains(err.Error(), "the repository for origin was not found") {
+		t.Errorf("unexpected message: %s", err)
+	}
+	if !strings.Contains(stderr.String(), "does not appear to be a git repository") {
+		t.Errorf("expected git's own output to pass through, got: %q", stderr.String())
+	}
+}
+
+func TestFetch_UnrecognisedNotRetried(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+	mustGit(t, dir, "config", "remote.origin.fetch", "refs/heads/*:refs/remotes/origin/*:bad")
+
+	Stderr = io.Discard
+
+	err := Fetch(t.Context(), "origin", FetchOptions{Retries: 3, Backoff: time.Millisecond})
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Fetch() error = %v, want *FetchError", err)
+	}
+	if fe.Failure != FetchFailed {
+		t.Errorf("Failure = %d, want FetchFailed", fe.Failure)
+	}
+	if fe.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", fe.Attempts)
+	}
+}
+
+func TestFetch_TimeoutRetried(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	// A transport that never answers.
+	mustGit(t, dir, "config", "protocol.ext.allow", "always")
+	mustGit(t, dir, "remote", "set-url", "origin", "ext::sleep 10")
+
+	var stderr bytes.Buffer
+	Stderr = &stderr
+
+	start := time.Now()
+	err := Fetch(t.Context(), "origin", FetchOptions{Timeout: 200 * time.Millisecond, Retries: 1, Backoff: time.Millisecond})
+	if elapsed := time.Since(start); elapsed > 8*time.Second {
+		t.Errorf("Fetch() took %s, timeout was not enforced", elapsed)
+	}
+
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Fetch() error = %v, want *FetchError", err)
+	}
+	if fe.Failure != FetchTimedOut {
+		t.Errorf("Failure = %d, want FetchTimedOut", fe.Failure)
+	}
+	if fe.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", fe.Attempts)
+	}
+	if want := "origin did not respond within 200ms after 2 attempts"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+	if !strings.Contains(stderr.String(), "retrying in 1ms") {
+		t.Errorf("expected a retry notice, got: %q", stderr.String())
+	}
+}
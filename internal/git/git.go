@@ -1,59 +1,145 @@
 package git
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Verbose controls whether git commands are logged to stderr.
-var Verbose bool
+// Logger receives a debug record for every git command run, with its
+// duration and exit code. It discards everything unless replaced.
+var Logger = slog.New(slog.DiscardHandler)
 
-// Color controls whether verbose output uses ANSI colors.
-var Color bool
+// RemotePreference is the order MainRemote tries remotes in, after any it
+// is asked to prefer and the one chosen with gh repo set-default.
+var RemotePreference = []string{"upstream", "github", "origin"}
 
-// Stderr is the writer for verbose output and fetch progress. Defaults to os.Stderr.
+// Stderr is the writer for fetch progress and retry notices. Defaults to os.Stderr.
 var Stderr io.Writer = os.Stderr
 
-// exec runs a git command and returns trimmed stdout. Stderr is suppressed.
-func execGit(args ...string) (string, error) {
-	logCmd(args)
-	cmd := exec.Command("git", args...)
-	out, err := cmd.Output()
+// Dir is the directory git commands run in. Empty means the current directory.
+var Dir string
+
+// command builds a git command that runs in Dir and is killed when ctx is
+// done.
+func command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = Dir
+	return cmd
+}
+
+// mutation builds a git command that changes refs, config or a work tree.
+// Once started it runs to completion: it ignores ctx being cancelled and
+// runs in its own process group, so a Ctrl-C at the terminal can't kill it
+// halfway either. It refuses to start if ctx is already done.
+func mutation(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cmd := command(context.WithoutCancel(ctx), args...)
+	isolate(cmd)
+	return cmd, nil
+}
+
+// exec runs a git command and returns trimmed stdout. A failure is returned
+// as an *Error carrying what git printed to stderr.
+func execGit(ctx context.Context, args ...string) (string, error) {
+	return output(ctx, command(ctx, args...))
+}
+
+// execGitInput runs a read-only git command with input on its stdin and
+// returns trimmed stdout.
+func execGitInput(ctx context.Context, input string, args ...string) (string, error) {
+	cmd := command(ctx, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return output(ctx, cmd)
+}
+
+// execMutation runs a git command built by mutation and returns trimmed
+// stdout. A failure is returned as an *Error.
+func execMutation(ctx context.Context, args ...string) (string, error) {
+	cmd, err := mutation(ctx, args...)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimRight(string(out), "\n"), nil
+	return output(ctx, cmd)
 }
 
-// Spawn runs a git command with full I/O passthrough to the terminal.
-func Spawn(args ...string) error {
-	logCmd(args)
-	cmd := exec.Command("git", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = Stderr
-	return cmd.Run()
+// output runs cmd and returns trimmed stdout, capturing stderr to explain a
+// failure.
+func output(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	args := cmd.Args[1:]
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		gitErr := newError(args, err, stderr.String())
+		logCommand(ctx, args, start, gitErr)
+		return "", gitErr
+	}
+	logCommand(ctx, args, start, nil)
+	return strings.TrimRight(string(out), "\n"), nil
 }
 
 // Run runs a git command silently and returns whether it succeeded.
-func Run(args ...string) bool {
-	logCmd(args)
-	return exec.Command("git", args...).Run() == nil
+func Run(ctx context.Context, args ...string) bool {
+	_, err := execGit(ctx, args...)
+	return err == nil
 }
 
-func logCmd(args []string) {
-	if !Verbose {
-		return
+// logCommand records a finished git command: its arguments and subcommand,
+// how long it took and how it ended, with everything it printed to stderr if
+// it failed.
+func logCommand(ctx context.Context, args []string, start time.Time, err *Error) {
+	attrs := []slog.Attr{
+		slog.String("args", formatArgs(args)),
+		slog.String("subcommand", subcommand(args)),
+		slog.Duration("duration", time.Since(start)),
 	}
-	if Color {
-		fmt.Fprintf(Stderr, "\033[35m$ git %s\033[0m\n", strings.Join(args, " "))
+	if Dir != "" {
+		attrs = append(attrs, slog.String("dir", Dir))
+	}
+	if err == nil {
+		attrs = append(attrs, slog.Int("exit", 0))
 	} else {
-		fmt.Fprintf(Stderr, "$ git %s\n", strings.Join(args, " "))
+		attrs = append(attrs, slog.Int("exit", err.ExitCode))
+		if err.ExitCode < 0 {
+			attrs = append(attrs, slog.String("error", err.Err.Error()))
+		}
+		if err.Stderr != "" {
+			attrs = append(attrs, slog.String("stderr", err.Stderr))
+		}
 	}
+	Logger.LogAttrs(ctx, slog.LevelDebug, "git", attrs...)
+}
+
+// subcommand returns the git subcommand in args, skipping global options
+// such as -C <path> and --git-dir <dir> that come before it.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-C", "-c", "--git-dir", "--work-tree", "--namespace":
+			i++
+		default:
+			if !strings.HasPrefix(arg, "-") {
+				return arg
+			}
+		}
+	}
+	return ""
 }
 
 func splitLines(s string) []string {
@@ -63,16 +149,22 @@ func splitLines(s string) []string {
 	return strings.Split(s, "\n")
 }
 
-// MainRemote returns the primary remote, preferring upstream > github > origin.
-func MainRemote() (string, error) {
-	out, err := execGit("remote")
+// MainRemote returns the primary remote. Remotes named in preference win in
+// the order given, then the remote chosen with gh repo set-default, then
+// those in RemotePreference, then the first remote.
+// It fails with ErrNoRemotes when there are none.
+func MainRemote(ctx context.Context, preference ...string) (string, error) {
+	out, err := execGit(ctx, "remote")
+	if errors.Is(err, ErrNotARepository) {
+		return "", ErrNotARepository
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to list remotes: %w", err)
 	}
 
 	remotes := splitLines(out)
 	if len(remotes) == 0 {
-		return "", fmt.Errorf("no git remotes found")
+		return "", ErrNoRemotes
 	}
 
 	known := map[string]bool{}
@@ -80,7 +172,8 @@ func MainRemote() (string, error) {
 		known[name] = true
 	}
 
-	for _, candidate := range []string{"upstream", "github", "origin"} {
+	candidates := slices.Concat(preference, []string{BaseRemote(ctx)}, RemotePreference)
+	for _, candidate := range candidates {
 		if known[candidate] {
 			return candidate, nil
 		}
@@ -89,28 +182,94 @@ func MainRemote() (string, error) {
 	return remotes[0], nil
 }
 
-// DefaultBranch resolves the default branch name for a remote.
-// Checks symbolic-ref first, then probes for main and master on the remote.
-func DefaultBranch(remote string) string {
+// DefaultBranch resolves the default branch name for a remote from
+// refs/remotes/<remote>/HEAD, and reports whether it came from there. When
+// that ref is missing it guesses main or master, whichever the remote has,
+// falling back to main, and reports false.
+func DefaultBranch(ctx context.Context, remote string) (string, bool) {
 	headRef := fmt.Sprintf("refs/remotes/%s/HEAD", remote)
-	if out, err := execGit("symbolic-ref", "--quiet", headRef); err == nil {
+	if out, err := execGit(ctx, "symbolic-ref", "--quiet", headRef); err == nil {
 		prefix := fmt.Sprintf("refs/remotes/%s/", remote)
-		return strings.TrimPrefix(out, prefix)
+		return strings.TrimPrefix(out, prefix), true
 	}
 
-	if HasRef(fmt.Sprintf("refs/remotes/%s/main", remote)) {
-		return "main"
+	if HasRef(ctx, fmt.Sprintf("refs/remotes/%s/main", remote)) {
+		return "main", false
+	}
+	if HasRef(ctx, fmt.Sprintf("refs/remotes/%s/master", remote)) {
+		return "master", false
 	}
-	if HasRef(fmt.Sprintf("refs/remotes/%s/master", remote)) {
-		return "master"
+
+	return "main", false
+}
+
+// RemoteDefaultBranch asks the remote which branch its HEAD points at, the
+// way git remote set-head --auto does.
+func RemoteDefaultBranch(ctx context.Context, remote string) (string, error) {
+	out, err := execGit(ctx, "ls-remote", "--symref", remote, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to ask %s for its default branch: %w", remote, err)
 	}
 
-	return "main"
+	for _, line := range splitLines(out) {
+		ref, name, ok := strings.Cut(line, "\t")
+		if ok && name == "HEAD" && strings.HasPrefix(ref, "ref: refs/heads/") {
+			return strings.TrimPrefix(ref, "ref: refs/heads/"), nil
+		}
+	}
+	return "", fmt.Errorf("%s did not say which branch its HEAD points at", remote)
+}
+
+// SetDefaultBranch records branch as the remote's default branch in
+// refs/remotes/<remote>/HEAD, so DefaultBranch finds it next time. The
+// remote-tracking branch must already exist.
+func SetDefaultBranch(ctx context.Context, remote, branch string) error {
+	if _, err := execMutation(ctx, "remote", "set-head", remote, branch); err != nil {
+		return fmt.Errorf("failed to set the default branch of %s: %w", remote, err)
+	}
+	return nil
+}
+
+// RepoInfo describes where the repository lives relative to the working
+// directory, which may be a linked worktree, a submodule, or a bare clone.
+type RepoInfo struct {
+	GitDir    string // the git dir of this worktree
+	CommonDir string // the git dir shared by every worktree
+	WorkTree  bool   // whether the working directory is inside a work tree
+}
+
+// Inspect resolves the repository containing the working directory, failing
+// with ErrNotARepository outside one.
+func Inspect(ctx context.Context) (*RepoInfo, error) {
+	out, err := execGit(ctx, "rev-parse", "--path-format=absolute",
+		"--git-dir", "--git-common-dir", "--is-inside-work-tree")
+	if errors.Is(err, ErrNotARepository) {
+		return nil, ErrNotARepository
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect repository: %w", err)
+	}
+
+	lines := splitLines(out)
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("unexpected rev-parse output: %q", out)
+	}
+	return &RepoInfo{GitDir: lines[0], CommonDir: lines[1], WorkTree: lines[2] == "true"}, nil
 }
 
 // CurrentBranch returns the name of the checked-out branch.
-func CurrentBranch() (string, error) {
-	out, err := execGit("symbolic-ref", "--short", "HEAD")
+func CurrentBranch(ctx context.Context) (string, error) {
+	out, err := execGit(ctx, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("not on any branch")
+	}
+	return out, nil
+}
+
+// HeadBranch returns the branch named by HEAD in the given git dir, such as
+// the HEAD of a bare repository shared by several worktrees.
+func HeadBranch(ctx context.Context, gitDir string) (string, error) {
+	out, err := execGit(ctx, "--git-dir", gitDir, "symbolic-ref", "--short", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("not on any branch")
 	}
@@ -118,23 +277,102 @@ func CurrentBranch() (string, error) {
 }
 
 // LocalBranches lists all local branch names.
-func LocalBranches() ([]string, error) {
-	out, err := execGit("branch", "--format=%(refname:short)")
+func LocalBranches(ctx context.Context) ([]string, error) {
+	out, err := execGit(ctx, "branch", "--format=%(refname:short)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list branches: %w", err)
 	}
 	return splitLines(out), nil
 }
 
-// Fetch fetches from a remote with pruning and progress output.
-func Fetch(remote string) error {
-	return Spawn("fetch", "--prune", "--quiet", "--progress", remote)
+// BranchInfo describes a local branch as reported by for-each-ref.
+type BranchInfo struct {
+	Name       string
+	CommitDate time.Time // committer date of the branch tip
+	Upstream   string    // short name of the upstream branch, if any
+	Track      string    // e.g. "[gone]" or "[ahead 1, behind 2]"; empty when in sync
+}
+
+// BranchInfos describes every local branch.
+func BranchInfos(ctx context.Context) ([]BranchInfo, error) {
+	out, err := execGit(ctx, "for-each-ref",
+		"--format=%(refname:short)%09%(committerdate:unix)%09%(upstream:short)%09%(upstream:track)",
+		"refs/heads")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	var infos []BranchInfo
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected for-each-ref output: %q", line)
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad commit date for %s: %w", fields[0], err)
+		}
+		infos = append(infos, BranchInfo{
+			Name:       fields[0],
+			CommitDate: time.Unix(unix, 0),
+			Upstream:   fields[2],
+			Track:      fields[3],
+		})
+	}
+	return infos, nil
+}
+
+// Worktree describes one entry of "git worktree list".
+type Worktree struct {
+	Path   string
+	Branch string // short name of the checked-out branch; empty if detached or bare
+	Bare   bool
+}
+
+// Worktrees lists the main worktree and every linked worktree of the repository.
+func Worktrees(ctx context.Context) ([]Worktree, error) {
+	out, err := execGit(ctx, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+	return parseWorktrees(out), nil
+}
+
+// parseWorktrees parses the blank-line separated records printed by
+// "git worktree list --porcelain".
+func parseWorktrees(out string) []Worktree {
+	var worktrees []Worktree
+	var wt *Worktree
+	for _, line := range splitLines(out) {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "worktree":
+			worktrees = append(worktrees, Worktree{Path: value})
+			wt = &worktrees[len(worktrees)-1]
+		case "branch":
+			if wt != nil {
+				wt.Branch = strings.TrimPrefix(value, "refs/heads/")
+			}
+		case "bare":
+			if wt != nil {
+				wt.Bare = true
+			}
+		}
+	}
+	return worktrees
+}
+
+// IsWorktreeClean reports whether the worktree at path has no uncommitted
+// changes to tracked files. An unreadable worktree is never considered clean.
+func IsWorktreeClean(ctx context.Context, path string) bool {
+	out, err := execGit(ctx, "-C", path, "status", "--porcelain", "--untracked-files=no")
+	return err == nil && out == ""
 }
 
 // BranchRemotes returns a mapping of local branch name to its configured
 // remote, parsed from branch.*.remote git config entries.
-func BranchRemotes() map[string]string {
-	out, err := execGit("config", "--get-regexp", `^branch\..*\.remote$`)
+func BranchRemotes(ctx context.Context) map[string]string {
+	out, err := execGit(ctx, "config", "--get-regexp", `^branch\..*\.remote$`)
 	if err != nil {
 		return nil
 	}
@@ -149,70 +387,215 @@ func BranchRemotes() map[string]string {
 	return result
 }
 
+// RemoteURLs returns a mapping of remote name to its fetch URL, parsed from
+// remote.*.url git config entries.
+func RemoteURLs(ctx context.Context) map[string]string {
+	out, err := execGit(ctx, "config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil {
+		return nil
+	}
+
+	re := regexp.MustCompile(`^remote\.(.+)\.url\s+(.+)$`)
+	result := make(map[string]string)
+	for _, line := range splitLines(out) {
+		if m := re.FindStringSubmatch(line); m != nil {
+			result[m[1]] = m[2]
+		}
+	}
+	return result
+}
+
+// BaseRemote returns the remote gh repo set-default chose as the base
+// repository, which gh records as remote.<name>.gh-resolved = base, or ""
+// when there is none.
+func BaseRemote(ctx context.Context) string {
+	out, err := execGit(ctx, "config", "--get-regexp", `^remote\..*\.gh-resolved$`)
+	if err != nil {
+		return ""
+	}
+
+	re := regexp.MustCompile(`^remote\.(.+)\.gh-resolved\s+base$`)
+	for _, line := range splitLines(out) {
+		if m := re.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// ConfigEntry is a single key/value pair from git config.
+type ConfigEntry struct {
+	Key   string // lowercased section and name, e.g. "gh-sync.protect"
+	Value string
+}
+
+// ConfigEntries returns every config entry whose key matches pattern, across
+// all scopes in the order git reads them (system, global, then repository),
+// so a later entry overrides an earlier one.
+func ConfigEntries(ctx context.Context, pattern string) ([]ConfigEntry, error) {
+	out, err := execGit(ctx, "config", "--get-regexp", pattern)
+	if err != nil {
+		// Exit status 1 means no key matched.
+		var gitErr *Error
+		if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read git config: %w", err)
+	}
+
+	var entries []ConfigEntry
+	for _, line := range splitLines(out) {
+		key, value, _ := strings.Cut(line, " ")
+		entries = append(entries, ConfigEntry{Key: key, Value: value})
+	}
+	return entries, nil
+}
+
 // UpstreamRef resolves the full upstream tracking ref for a local branch.
-func UpstreamRef(branch string) (string, error) {
-	return execGit("rev-parse", "--symbolic-full-name", branch+"@{upstream}")
+func UpstreamRef(ctx context.Context, branch string) (string, error) {
+	return execGit(ctx, "rev-parse", "--symbolic-full-name", branch+"@{upstream}")
+}
+
+// Submodules returns the absolute paths of the initialized submodules listed
+// in the .gitmodules file at the top of the current work tree. Outside a work
+// tree, or without a .gitmodules file, there are none.
+func Submodules(ctx context.Context) ([]string, error) {
+	top, err := execGit(ctx, "rev-parse", "--show-toplevel")
+	if err != nil || top == "" {
+		return nil, nil
+	}
+
+	gitmodules := filepath.Join(top, ".gitmodules")
+	if _, err := os.Stat(gitmodules); err != nil {
+		return nil, nil
+	}
+
+	out, err := execGit(ctx, "config", "--file", gitmodules, "--get-regexp", `^submodule\..*\.path$`)
+	if err != nil {
+		// Exit status 1 means the file lists no submodule paths.
+		var gitErr *Error
+		if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", gitmodules, err)
+	}
+
+	var paths []string
+	for _, line := range splitLines(out) {
+		_, path, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		abs := filepath.Join(top, path)
+		// An uninitialized submodule is just an empty directory.
+		if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil {
+			paths = append(paths, abs)
+		}
+	}
+	return paths, nil
 }
 
 // HasRef checks whether a fully-qualified ref exists.
-func HasRef(ref string) bool {
-	return Run("show-ref", "--verify", "--quiet", ref)
+func HasRef(ctx context.Context, ref string) bool {
+	return Run(ctx, "show-ref", "--verify", "--quiet", ref)
 }
 
 // RevParse resolves refs to their SHA hashes.
-func RevParse(refs ...string) ([]string, error) {
+func RevParse(ctx context.Context, refs ...string) ([]string, error) {
 	args := make([]string, 0, 2+len(refs))
 	args = append(args, "rev-parse", "--quiet")
 	args = append(args, refs...)
-	out, err := execGit(args...)
+	out, err := execGit(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
 	return splitLines(out), nil
 }
 
-// MergeFFOnly fast-forwards the current branch to the given ref.
-func MergeFFOnly(ref string) error {
-	_, err := execGit("merge", "--ff-only", "--quiet", ref)
+// MergeFFOnly fast-forwards the current branch to the given ref. The error
+// matches ErrDirtyWorktree when local changes are in the way, and
+// ErrNonFastForward when the branch has moved on.
+func MergeFFOnly(ctx context.Context, ref string) error {
+	_, err := execMutation(ctx, "merge", "--ff-only", "--quiet", ref)
 	return err
 }
 
-// UpdateRef points a ref at the commit identified by target.
-func UpdateRef(ref, target string) error {
-	_, err := execGit("update-ref", ref, target)
+// MergeFFOnlyAt fast-forwards the branch checked out in the worktree at path.
+func MergeFFOnlyAt(ctx context.Context, path, ref string) error {
+	_, err := execMutation(ctx, "-C", path, "merge", "--ff-only", "--quiet", ref)
 	return err
 }
 
-// DeleteBranch force-deletes a local branch.
-func DeleteBranch(name string) error {
-	_, err := execGit("branch", "-D", name)
+// BranchUpstream returns the branch.<name>.remote and branch.<name>.merge
+// config of a branch, empty when it has none.
+func BranchUpstream(ctx context.Context, name string) (remote, merge string) {
+	remote, _ = execGit(ctx, "config", "--get", "branch."+name+".remote")
+	merge, _ = execGit(ctx, "config", "--get", "branch."+name+".merge")
+	return remote, merge
+}
+
+// SetBranchUpstream writes the branch.<name>.remote and branch.<name>.merge
+// config of a branch. Unlike git branch --set-upstream-to, the remote branch
+// needn't exist.
+func SetBranchUpstream(ctx context.Context, name, remote, merge string) error {
+	if _, err := execMutation(ctx, "config", "branch."+name+".remote", remote); err != nil {
+		return err
+	}
+	_, err := execMutation(ctx, "config", "branch."+name+".merge", merge)
 	return err
 }
 
+// RemoveBranchConfig drops the branch.<name>.* config section, as
+// "git branch -D" would. A missing section is not an error.
+func RemoveBranchConfig(ctx context.Context, name string) {
+	execMutation(ctx, "config", "--remove-section", "branch."+name)
+}
+
 // Checkout switches to the named branch quietly.
-func Checkout(branch string) error {
-	_, err := execGit("checkout", "--quiet", branch)
+func Checkout(ctx context.Context, branch string) error {
+	_, err := execMutation(ctx, "checkout", "--quiet", branch)
 	return err
 }
 
 // MergeBase returns the best common ancestor commit of two refs.
-func MergeBase(a, b string) (string, error) {
-	return execGit("merge-base", a, b)
+func MergeBase(ctx context.Context, a, b string) (string, error) {
+	return execGit(ctx, "merge-base", a, b)
 }
 
-// TreeHash returns the tree object SHA for a commit ref.
-func TreeHash(ref string) (string, error) {
-	return execGit("rev-parse", ref+"^{tree}")
+// DiffPatchID returns the stable patch ID of the changes from one commit to
+// another, or "" when there are none. Unlike a temporary commit, it writes
+// nothing to the object database.
+func DiffPatchID(ctx context.Context, from, to string) (string, error) {
+	diff, err := execGit(ctx, "diff", "--no-color", "--no-ext-diff", from, to)
+	if err != nil || diff == "" {
+		return "", err
+	}
+	out, err := execGitInput(ctx, diff+"\n", "patch-id", "--stable")
+	if err != nil {
+		return "", err
+	}
+	id, _, _ := strings.Cut(out, " ")
+	return id, nil
 }
 
-// CommitTree creates a commit object from a tree, parent, and message.
-func CommitTree(tree, parent, message string) (string, error) {
-	return execGit("commit-tree", tree, "-p", parent, "-m", message)
-}
+// CommitPatchIDs maps the stable patch ID of each non-merge commit in
+// from..to to that commit, so a diff can be looked up among them the way
+// git cherry does.
+func CommitPatchIDs(ctx context.Context, from, to string) (map[string]string, error) {
+	log, err := execGit(ctx, "log", "-p", "--no-color", "--no-ext-diff", "--no-merges", from+".."+to)
+	if err != nil || log == "" {
+		return nil, err
+	}
+	out, err := execGitInput(ctx, log+"\n", "patch-id", "--stable")
+	if err != nil {
+		return nil, err
+	}
 
-// Cherry checks whether a commit's patch exists in an upstream branch.
-// The output line starts with "-" if already applied, "+" if not.
-func Cherry(upstream, head string) (string, error) {
-	return execGit("cherry", upstream, head)
+	ids := make(map[string]string)
+	for _, line := range splitLines(out) {
+		if id, commit, ok := strings.Cut(line, " "); ok {
+			ids[id] = commit
+		}
+	}
+	return ids, nil
 }
-
@@ -1,9 +1,13 @@
 package git
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,11 +30,34 @@ func TestSplitLines(t *testing.T) {
 	}
 }
 
+func TestParseWorktrees(t *testing.T) {
+	out := "worktree /src/repo\nHEAD 1111111111111111111111111111111111111111\nbranch refs/heads/main\n\n" +
+		"worktree /src/feature\nHEAD 2222222222222222222222222222222222222222\nbranch refs/heads/feature/x\n\n" +
+		"worktree /src/detached\nHEAD 3333333333333333333333333333333333333333\ndetached\n\n" +
+		"worktree /src/bare.git\nbare"
+
+	got := parseWorktrees(out)
+	want := []Worktree{
+		{Path: "/src/repo", Branch: "main"},
+		{Path: "/src/feature", Branch: "feature/x"},
+		{Path: "/src/detached"},
+		{Path: "/src/bare.git", Bare: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseWorktrees() returned %d worktrees, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseWorktrees()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
 func TestMainRemote(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	remote, err := MainRemote()
+	remote, err := MainRemote(t.Context())
 	if err != nil {
 		t.Fatalf("MainRemote() error: %v", err)
 	}
@@ -45,7 +72,7 @@ func TestMainRemote_PrefersUpstream(t *testing.T) {
 
 	mustGit(t, dir, "remote", "add", "upstream", "https://example.com/upstream.git")
 
-	remote, err := MainRemote()
+	remote, err := MainRemote(t.Context())
 	if err != nil {
 		t.Fatalf("MainRemote() error: %v", err)
 	}
@@ -54,14 +81,75 @@ func TestMainRemote_PrefersUpstream(t *testing.T) {
 	}
 }
 
+func TestMainRemote_Preference(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	mustGit(t, dir, "remote", "add", "upstream", "https://example.com/upstream.git")
+
+	remote, err := MainRemote(t.Context(), "missing", "origin")
+	if err != nil {
+		t.Fatalf("MainRemote() error: %v", err)
+	}
+	if remote != "origin" {
+		t.Errorf("MainRemote() = %q, want %q", remote, "origin")
+	}
+}
+
+func TestMainRemote_SetDefault(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	mustGit(t, dir, "remote", "add", "upstream", "https://example.com/upstream.git")
+	mustGit(t, dir, "config", "remote.origin.gh-resolved", "base")
+
+	remote, err := MainRemote(t.Context())
+	if err != nil {
+		t.Fatalf("MainRemote() error: %v", err)
+	}
+	if remote != "origin" {
+		t.Errorf("MainRemote() = %q, want %q", remote, "origin")
+	}
+
+	// Remotes asked for explicitly still come first.
+	remote, err = MainRemote(t.Context(), "upstream")
+	if err != nil {
+		t.Fatalf("MainRemote() error: %v", err)
+	}
+	if remote != "upstream" {
+		t.Errorf("MainRemote(upstream) = %q, want %q", remote, "upstream")
+	}
+}
+
+func TestBaseRemote(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	if got := BaseRemote(t.Context()); got != "" {
+		t.Errorf("BaseRemote() = %q, want none", got)
+	}
+
+	// gh records a fork's parent as OWNER/REPO rather than base.
+	mustGit(t, dir, "remote", "add", "upstream", "https://example.com/upstream.git")
+	mustGit(t, dir, "config", "remote.origin.gh-resolved", "octo/upstream")
+	if got := BaseRemote(t.Context()); got != "" {
+		t.Errorf("BaseRemote() = %q, want none", got)
+	}
+
+	mustGit(t, dir, "config", "remote.upstream.gh-resolved", "base")
+	if got := BaseRemote(t.Context()); got != "upstream" {
+		t.Errorf("BaseRemote() = %q, want %q", got, "upstream")
+	}
+}
+
 func TestMainRemote_NoRemotes(t *testing.T) {
 	dir := t.TempDir()
 	mustGit(t, dir, "init", "-b", "main")
 	chdir(t, dir)
 
-	_, err := MainRemote()
-	if err == nil {
-		t.Fatal("expected error when no remotes exist")
+	_, err := MainRemote(t.Context())
+	if !errors.Is(err, ErrNoRemotes) {
+		t.Fatalf("MainRemote() error = %v, want ErrNoRemotes", err)
 	}
 }
 
@@ -69,9 +157,124 @@ func TestDefaultBranch(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	branch := DefaultBranch("origin")
-	if branch != "main" {
-		t.Errorf("DefaultBranch() = %q, want %q", branch, "main")
+	branch, ok := DefaultBranch(t.Context(), "origin")
+	if branch != "main" || !ok {
+		t.Errorf("DefaultBranch() = %q, %t, want %q, true", branch, ok, "main")
+	}
+}
+
+func TestDefaultBranch_Missing(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	// The remote's default is trunk, but the clone lost its HEAD ref.
+	remoteDir := filepath.Join(filepath.Dir(dir), "remote.git")
+	mustGit(t, remoteDir, "branch", "trunk", "main")
+	mustGit(t, remoteDir, "symbolic-ref", "HEAD", "refs/heads/trunk")
+	mustGit(t, dir, "fetch", "origin")
+	mustGit(t, dir, "remote", "set-head", "origin", "--delete")
+
+	branch, ok := DefaultBranch(t.Context(), "origin")
+	if branch != "main" || ok {
+		t.Errorf("DefaultBranch() = %q, %t, want a guess of %q", branch, ok, "main")
+	}
+
+	got, err := RemoteDefaultBranch(t.Context(), "origin")
+	if err != nil {
+		t.Fatalf("RemoteDefaultBranch() error: %v", err)
+	}
+	if got != "trunk" {
+		t.Errorf("RemoteDefaultBranch() = %q, want %q", got, "trunk")
+	}
+
+	if err := SetDefaultBranch(t.Context(), "origin", got); err != nil {
+		t.Fatalf("SetDefaultBranch() error: %v", err)
+	}
+	branch, ok = DefaultBranch(t.Context(), "origin")
+	if branch != "trunk" || !ok {
+		t.Errorf("DefaultBranch() after SetDefaultBranch = %q, %t, want %q, true", branch, ok, "trunk")
+	}
+}
+
+func TestPatchIDs(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	// Two commits on a branch, squashed into one on main.
+	mustGit(t, dir, "checkout", "-b", "topic")
+	writeFile(t, filepath.Join(dir, "a.txt"), "a\n")
+	mustGit(t, dir, "add", ".")
+	mustGit(t, dir, "commit", "-m", "add a")
+	writeFile(t, filepath.Join(dir, "b.txt"), "b\n")
+	mustGit(t, dir, "add", ".")
+	mustGit(t, dir, "commit", "-m", "add b")
+	mustGit(t, dir, "checkout", "main")
+	mustGit(t, dir, "merge", "--squash", "topic")
+	mustGit(t, dir, "commit", "-m", "squashed topic")
+	squashed := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	base := strings.TrimSpace(mustGit(t, dir, "merge-base", "main", "topic"))
+
+	objects := mustGit(t, dir, "count-objects")
+
+	id, err := DiffPatchID(t.Context(), base, "topic")
+	if err != nil || id == "" {
+		t.Fatalf("DiffPatchID() = %q, %v", id, err)
+	}
+	ids, err := CommitPatchIDs(t.Context(), base, "main")
+	if err != nil {
+		t.Fatalf("CommitPatchIDs() error: %v", err)
+	}
+	if ids[id] != squashed {
+		t.Errorf("CommitPatchIDs()[%s] = %q, want the squashed commit %s", id, ids[id], squashed)
+	}
+
+	if id, err := DiffPatchID(t.Context(), "topic", "topic"); id != "" || err != nil {
+		t.Errorf("DiffPatchID() of no changes = %q, %v, want none", id, err)
+	}
+	if after := mustGit(t, dir, "count-objects"); after != objects {
+		t.Errorf("patch IDs wrote objects: %q before, %q after", objects, after)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	repo, err := Inspect(t.Context())
+	if err != nil {
+		t.Fatalf("Inspect() error: %v", err)
+	}
+	if !repo.WorkTree {
+		t.Error("Inspect().WorkTree = false, want true")
+	}
+	if repo.GitDir != repo.CommonDir {
+		t.Errorf("GitDir = %q, CommonDir = %q, want them equal outside a linked worktree", repo.GitDir, repo.CommonDir)
+	}
+
+	wt := filepath.Join(filepath.Dir(dir), "linked")
+	mustGit(t, dir, "worktree", "add", "-b", "linked", wt)
+	chdir(t, wt)
+
+	linked, err := Inspect(t.Context())
+	if err != nil {
+		t.Fatalf("Inspect() in linked worktree error: %v", err)
+	}
+	if linked.CommonDir != repo.CommonDir {
+		t.Errorf("linked CommonDir = %q, want %q", linked.CommonDir, repo.CommonDir)
+	}
+	if linked.GitDir == repo.GitDir {
+		t.Errorf("linked GitDir = %q, want it to differ from the main git dir", linked.GitDir)
+	}
+}
+
+func TestInspect_NotARepository(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	if _, err := Inspect(t.Context()); !errors.Is(err, ErrNotARepository) {
+		t.Fatalf("Inspect() error = %v, want ErrNotARepository", err)
+	}
+	if _, err := MainRemote(t.Context()); !errors.Is(err, ErrNotARepository) {
+		t.Fatalf("MainRemote() error = %v, want ErrNotARepository", err)
 	}
 }
 
@@ -79,7 +282,7 @@ func TestCurrentBranch(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	branch, err := CurrentBranch()
+	branch, err := CurrentBranch(t.Context())
 	if err != nil {
 		t.Fatalf("CurrentBranch() error: %v", err)
 	}
@@ -96,7 +299,7 @@ func TestLocalBranches(t *testing.T) {
 	mustGit(t, dir, "checkout", "main")
 	mustGit(t, dir, "checkout", "-b", "feature-b")
 
-	branches, err := LocalBranches()
+	branches, err := LocalBranches(t.Context())
 	if err != nil {
 		t.Fatalf("LocalBranches() error: %v", err)
 	}
@@ -118,7 +321,7 @@ func TestBranchRemotes(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	result := BranchRemotes()
+	result := BranchRemotes(t.Context())
 	if result == nil {
 		t.Fatal("BranchRemotes() returned nil")
 	}
@@ -127,14 +330,28 @@ func TestBranchRemotes(t *testing.T) {
 	}
 }
 
+func TestRemoteURLs(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+	mustGit(t, dir, "remote", "add", "my.fork", "git@github.com:me/project.git")
+
+	result := RemoteURLs(t.Context())
+	if result["my.fork"] != "git@github.com:me/project.git" {
+		t.Errorf("RemoteURLs()[my.fork] = %q, want the fork's URL", result["my.fork"])
+	}
+	if result["origin"] == "" {
+		t.Error("RemoteURLs() has no URL for origin")
+	}
+}
+
 func TestHasRef(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	if !HasRef("refs/heads/main") {
+	if !HasRef(t.Context(), "refs/heads/main") {
 		t.Error("HasRef(refs/heads/main) = false, want true")
 	}
-	if HasRef("refs/heads/nonexistent") {
+	if HasRef(t.Context(), "refs/heads/nonexistent") {
 		t.Error("HasRef(refs/heads/nonexistent) = true, want false")
 	}
 }
@@ -143,7 +360,7 @@ func TestRevParse(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	shas, err := RevParse("refs/heads/main")
+	shas, err := RevParse(t.Context(), "refs/heads/main")
 	if err != nil {
 		t.Fatalf("RevParse() error: %v", err)
 	}
@@ -155,6 +372,24 @@ func TestRevParse(t *testing.T) {
 	}
 }
 
+func TestMutation_OutlivesCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cmd, err := mutation(ctx, "version")
+	if err != nil {
+		t.Fatalf("mutation() error: %v", err)
+	}
+
+	// Cancelling after the command is built must not stop it.
+	cancel()
+	if err := cmd.Run(); err != nil {
+		t.Errorf("Run() after cancel error: %v", err)
+	}
+
+	if _, err := mutation(ctx, "version"); !errors.Is(err, context.Canceled) {
+		t.Errorf("mutation() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
 // initTestRepo creates a temporary git repo with a remote and an initial commit.
 func initTestRepo(t *testing.T) string {
 	t.Helper()
@@ -192,9 +427,9 @@ func chdir(t *testing.T, dir string) {
 	}
 	t.Cleanup(func() { os.Chdir(orig) })
 
-	Verbose = false
-	Color = false
+	Logger = slog.New(slog.DiscardHandler)
 	Stderr = os.Stderr
+	Dir = ""
 }
 
 func mustGit(t *testing.T, dir string, args ...string) string {
@@ -0,0 +1,14 @@
+//go:build unix
+
+package git
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts cmd in a process group of its own, so the SIGINT the
+// terminal sends to the foreground group on Ctrl-C doesn't reach it.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
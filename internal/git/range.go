@@ -1,6 +1,9 @@
 package git
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Range holds two resolved commit SHAs for comparison.
 type Range struct {
@@ -9,8 +12,8 @@ type Range struct {
 }
 
 // NewRange resolves two refs into their commit SHAs.
-func NewRange(a, b string) (*Range, error) {
-	shas, err := RevParse(a, b)
+func NewRange(ctx context.Context, a, b string) (*Range, error) {
+	shas, err := RevParse(ctx, a, b)
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +30,12 @@ func (r *Range) IsIdentical() bool {
 
 // IsAncestor returns true when A is an ancestor of B,
 // meaning B is strictly ahead and a fast-forward is possible.
-func (r *Range) IsAncestor() bool {
-	return Run("merge-base", "--is-ancestor", r.A, r.B)
+func (r *Range) IsAncestor(ctx context.Context) bool {
+	return Run(ctx, "merge-base", "--is-ancestor", r.A, r.B)
+}
+
+// IsDescendant returns true when B is an ancestor of A,
+// meaning A is strictly ahead and has commits B lacks.
+func (r *Range) IsDescendant(ctx context.Context) bool {
+	return Run(ctx, "merge-base", "--is-ancestor", r.B, r.A)
 }
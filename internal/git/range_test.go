@@ -21,7 +21,7 @@ func TestNewRange(t *testing.T) {
 	dir := initTestRepo(t)
 	chdir(t, dir)
 
-	r, err := NewRange("refs/heads/main", "refs/remotes/origin/main")
+	r, err := NewRange(t.Context(), "refs/heads/main", "refs/remotes/origin/main")
 	if err != nil {
 		t.Fatalf("NewRange() error: %v", err)
 	}
@@ -40,7 +40,7 @@ func TestRange_IsAncestor(t *testing.T) {
 	mustGit(t, dir, "commit", "-m", "second")
 
 	// Now local main is ahead of origin/main
-	r, err := NewRange("refs/remotes/origin/main", "refs/heads/main")
+	r, err := NewRange(t.Context(), "refs/remotes/origin/main", "refs/heads/main")
 	if err != nil {
 		t.Fatalf("NewRange() error: %v", err)
 	}
@@ -48,16 +48,42 @@ func TestRange_IsAncestor(t *testing.T) {
 	if r.IsIdentical() {
 		t.Error("should not be identical after new commit")
 	}
-	if !r.IsAncestor() {
+	if !r.IsAncestor(t.Context()) {
 		t.Error("origin/main should be ancestor of local main")
 	}
 
 	// Check the reverse
-	r2, err := NewRange("refs/heads/main", "refs/remotes/origin/main")
+	r2, err := NewRange(t.Context(), "refs/heads/main", "refs/remotes/origin/main")
 	if err != nil {
 		t.Fatalf("NewRange() error: %v", err)
 	}
-	if r2.IsAncestor() {
+	if r2.IsAncestor(t.Context()) {
 		t.Error("local main should NOT be ancestor of origin/main")
 	}
 }
+
+func TestRange_IsDescendant(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	writeFile(t, filepath.Join(dir, "second.txt"), "second\n")
+	mustGit(t, dir, "add", ".")
+	mustGit(t, dir, "commit", "-m", "second")
+
+	// Local main is ahead of origin/main
+	r, err := NewRange(t.Context(), "refs/heads/main", "refs/remotes/origin/main")
+	if err != nil {
+		t.Fatalf("NewRange() error: %v", err)
+	}
+	if !r.IsDescendant(t.Context()) {
+		t.Error("local main should be a descendant of origin/main")
+	}
+
+	r2, err := NewRange(t.Context(), "refs/remotes/origin/main", "refs/heads/main")
+	if err != nil {
+		t.Fatalf("NewRange() error: %v", err)
+	}
+	if r2.IsDescendant(t.Context()) {
+		t.Error("origin/main should NOT be a descendant of local main")
+	}
+}
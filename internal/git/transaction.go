@@ -0,0 +1,101 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrRefChanged is returned when a ref no longer holds the value a caller
+// expected, meaning another process moved it since it was read.
+var ErrRefChanged = errors.New("ref was changed by another process")
+
+// Transaction batches ref updates and deletions so they are applied together
+// through a single "git update-ref --stdin" session, or not at all.
+type Transaction struct {
+	message string
+	lines   []string
+}
+
+// NewTransaction starts an empty transaction whose updates are recorded in
+// the reflog with message.
+func NewTransaction(message string) *Transaction {
+	return &Transaction{message: message}
+}
+
+// Update queues moving ref from oldValue to newValue.
+func (t *Transaction) Update(ref, newValue, oldValue string) {
+	t.lines = append(t.lines, fmt.Sprintf("update %s %s %s", ref, newValue, oldValue))
+}
+
+// Create queues creating ref at newValue, provided it doesn't exist yet.
+func (t *Transaction) Create(ref, newValue string) {
+	t.lines = append(t.lines, fmt.Sprintf("create %s %s", ref, newValue))
+}
+
+// Delete queues deleting ref, provided it still points at oldValue.
+func (t *Transaction) Delete(ref, oldValue string) {
+	t.lines = append(t.lines, fmt.Sprintf("delete %s %s", ref, oldValue))
+}
+
+// Len returns the number of queued updates and deletions.
+func (t *Transaction) Len() int {
+	return len(t.lines)
+}
+
+// Commit applies every queued change atomically. If any ref no longer holds
+// its expected old value, nothing is changed and ErrRefChanged is returned.
+// Committing an empty transaction is a no-op.
+//
+// Once started, the transaction runs to completion even if ctx is cancelled;
+// it is only skipped when ctx is already done.
+func (t *Transaction) Commit(ctx context.Context) error {
+	if len(t.lines) == 0 {
+		return nil
+	}
+
+	args := []string{"update-ref", "--stdin", "-m", t.message}
+
+	input := "start\n" + strings.Join(t.lines, "\n") + "\nprepare\ncommit\n"
+
+	cmd, err := mutation(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		gitErr := newError(args, err, stderr.String())
+		logCommand(ctx, args, start, gitErr)
+		if isRefConflict(gitErr.Stderr) {
+			return fmt.Errorf("%w: %s", ErrRefChanged, conflictingRef(gitErr.Stderr))
+		}
+		return gitErr
+	}
+	logCommand(ctx, args, start, nil)
+	return nil
+}
+
+// isRefConflict reports whether update-ref failed because a ref did not hold
+// its expected old value.
+func isRefConflict(stderr string) bool {
+	return strings.Contains(stderr, "but expected") ||
+		strings.Contains(stderr, "unable to resolve reference") ||
+		strings.Contains(stderr, "reference already exists")
+}
+
+// conflictingRef extracts the ref name from a "cannot lock ref '<ref>'" message.
+func conflictingRef(stderr string) string {
+	_, rest, ok := strings.Cut(stderr, "cannot lock ref '")
+	if !ok {
+		return stderr
+	}
+	ref, _, _ := strings.Cut(rest, "'")
+	return ref
+}
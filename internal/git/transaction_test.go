@@ -0,0 +1,99 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransaction_Commit(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	old := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	mustGit(t, dir, "commit", "--allow-empty", "-m", "second")
+	next := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	mustGit(t, dir, "branch", "feature", old)
+	mustGit(t, dir, "branch", "stale", old)
+
+	tx := NewTransaction("gh-sync: fast-forward")
+	tx.Update("refs/heads/feature", next, old)
+	tx.Delete("refs/heads/stale", old)
+	if tx.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", tx.Len())
+	}
+
+	if err := tx.Commit(t.Context()); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	got := strings.TrimSpace(mustGit(t, dir, "rev-parse", "refs/heads/feature"))
+	if got != next {
+		t.Errorf("feature = %s, want %s", got, next)
+	}
+	if HasRef(t.Context(), "refs/heads/stale") {
+		t.Error("stale should have been deleted")
+	}
+
+	reflog := mustGit(t, dir, "reflog", "-1", "--format=%gs", "refs/heads/feature")
+	if strings.TrimSpace(reflog) != "gh-sync: fast-forward" {
+		t.Errorf("reflog message = %q, want %q", reflog, "gh-sync: fast-forward")
+	}
+}
+
+func TestTransaction_ConflictAppliesNothing(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	old := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	mustGit(t, dir, "commit", "--allow-empty", "-m", "second")
+	next := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	mustGit(t, dir, "branch", "feature", old)
+
+	// moved already points at next, so expecting old must fail.
+	mustGit(t, dir, "branch", "moved", next)
+
+	tx := NewTransaction("gh-sync: fast-forward")
+	tx.Update("refs/heads/feature", next, old)
+	tx.Update("refs/heads/moved", old, old)
+
+	err := tx.Commit(t.Context())
+	if !errors.Is(err, ErrRefChanged) {
+		t.Fatalf("Commit() error = %v, want ErrRefChanged", err)
+	}
+	if !strings.Contains(err.Error(), "refs/heads/moved") {
+		t.Errorf("Commit() error = %q, want it to name refs/heads/moved", err)
+	}
+
+	got := strings.TrimSpace(mustGit(t, dir, "rev-parse", "refs/heads/feature"))
+	if got != old {
+		t.Errorf("feature = %s, want it left at %s", got, old)
+	}
+}
+
+func TestTransaction_Empty(t *testing.T) {
+	if err := NewTransaction("unused").Commit(t.Context()); err != nil {
+		t.Errorf("Commit() on empty transaction error: %v", err)
+	}
+}
+
+func TestTransaction_CancelledBeforeStart(t *testing.T) {
+	dir := initTestRepo(t)
+	chdir(t, dir)
+
+	old := strings.TrimSpace(mustGit(t, dir, "rev-parse", "HEAD"))
+	mustGit(t, dir, "branch", "stale", old)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	tx := NewTransaction("gh-sync: delete")
+	tx.Delete("refs/heads/stale", old)
+	if err := tx.Commit(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Commit() error = %v, want context.Canceled", err)
+	}
+	if !HasRef(t.Context(), "refs/heads/stale") {
+		t.Error("stale should not have been deleted")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// logger records the decisions a run makes. It shares its handlers with
+// git.Logger, and like it discards everything until setupLogging replaces it.
+var logger = slog.New(slog.DiscardHandler)
+
+// logFile is the --log-file trace, open from setupLogging to closeLogging.
+var logFile *os.File
+
+// setupLogging sends log records to stderr when opts.verbose is set, and as
+// JSON lines to opts.logFile when one is given. Both see every record, down
+// to the debug record for each git command. With opts.timings, those records
+// are also added up for printTimings.
+func setupLogging(stderr io.Writer, opts options) error {
+	var handlers []slog.Handler
+	if opts.verbose {
+		handlers = append(handlers, &consoleHandler{w: stderr, theme: newTheme(opts.color, stderr)})
+	}
+	if opts.logFile != "" {
+		f, err := os.Create(opts.logFile)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %w", err)
+		}
+		logFile = f
+		handlers = append(handlers, slog.NewJSONHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	if opts.timings {
+		timings = newTimingsHandler()
+		handlers = append(handlers, timings)
+	}
+
+	switch len(handlers) {
+	case 0:
+		logger = slog.New(slog.DiscardHandler)
+	case 1:
+		logger = slog.New(handlers[0])
+	default:
+		logger = slog.New(multiHandler(handlers))
+	}
+	git.Logger = logger
+	return nil
+}
+
+// closeLogging closes the log file, if any, and goes back to discarding
+// records.
+func closeLogging() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	timings = nil
+	logger = slog.New(slog.DiscardHandler)
+	git.Logger = logger
+}
+
+// logDecision records what sync decided to do with a branch in the given
+// state: update, delete, keep, warn or none, with the reason if there is one.
+func logDecision(ctx context.Context, branch string, state branchState, action, reason string) {
+	attrs := []slog.Attr{
+		slog.String("branch", branch),
+		slog.String("state", state.String()),
+		slog.String("action", action),
+	}
+	if reason != "" {
+		attrs = append(attrs, slog.String("reason", reason))
+	}
+	logger.LogAttrs(ctx, slog.LevelInfo, "decision", attrs...)
+}
+
+// consoleHandler writes records for a person reading -v output: each git
+// command as it would be typed, followed by its exit status and stderr if it
+// failed, and any other record as its message and attributes on one line.
+type consoleHandler struct {
+	w     io.Writer
+	theme theme
+	attrs []slog.Attr
+}
+
+func (h *consoleHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	if r.Message == "git" {
+		return h.handleGit(attrs)
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Message)
+	for _, a := range attrs {
+		fmt.Fprintf(&b, " %s=%s", a.Key, formatValue(a.Value))
+	}
+	_, err := fmt.Fprintln(h.w, b.String())
+	return err
+}
+
+// handleGit writes a record logged by git.Logger for a finished command.
+func (h *consoleHandler) handleGit(attrs []slog.Attr) error {
+	var args, stderr, errMsg string
+	exit := int64(0)
+	for _, a := range attrs {
+		switch a.Key {
+		case "args":
+			args = a.Value.String()
+		case "exit":
+			exit = a.Value.Int64()
+		case "error":
+			errMsg = a.Value.String()
+		case "stderr":
+			stderr = a.Value.String()
+		}
+	}
+
+	fmt.Fprintln(h.w, h.theme.command("$ git "+args))
+	switch {
+	case exit > 0:
+		fmt.Fprintln(h.w, h.theme.failure(fmt.Sprintf("  exit status %d", exit)))
+	case exit < 0:
+		fmt.Fprintln(h.w, h.theme.failure("  "+errMsg))
+	}
+	for line := range strings.Lines(stderr) {
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			fmt.Fprintln(h.w, h.theme.failure("  "+line))
+		}
+	}
+	return nil
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &consoleHandler{w: h.w, theme: h.theme, attrs: append(slices.Clone(h.attrs), attrs...)}
+}
+
+// WithGroup is a no-op: nothing in gh-sync logs with groups, and a flat
+// line reads better on a terminal.
+func (h *consoleHandler) WithGroup(string) slog.Handler { return h }
+
+// formatValue quotes a value that would otherwise be hard to pick out of a
+// key=value line.
+func formatValue(v slog.Value) string {
+	s := v.String()
+	if s == "" || strings.ContainsAny(s, " \"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// multiHandler passes every record to each of its handlers that wants it.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -1,213 +1,625 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/mattn/go-isatty"
 	"github.com/wassimk/gh-sync/internal/git"
 )
 
-var errHelp = errors.New("help requested")
+// Exit codes let scripts tell outcomes apart without parsing stderr.
+const (
+	exitOK          = 0 // every branch is clean
+	exitFailure     = 1 // a git operation failed, possibly after some branches were updated
+	exitUsage       = 2 // invalid command-line arguments
+	exitWarnings    = 3 // the run completed but warned about unpushed or unmerged branches
+	exitFetchFailed = 4 // fetching from the remote failed
+	exitNoRepo      = 5 // not inside a git repository, or no remotes are configured
 
-func main() {
-	verbose, err := parseArgs(os.Args[1:])
-	if err != nil {
-		if errors.Is(err, errHelp) {
-			printUsage(os.Stdout)
-			os.Exit(0)
-		}
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
-		os.Exit(1)
-	}
+	exitInterrupted = 130 // stopped early by SIGINT or SIGTERM, as shells report Ctrl-C
+)
 
-	useColor := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
-	git.Verbose = verbose
-	git.Color = useColor
+// exitError attaches a specific exit code to an error returned by sync.
+type exitError struct {
+	code int
+	err  error
+}
 
-	if err := sync(os.Stdout, os.Stderr, useColor); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
-		os.Exit(1)
-	}
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// result records what a sync run did and found worth reporting.
+type result struct {
+	remote        string
+	defaultBranch string
+	branches      []branchReport
+	warnings      int
+	summary       summary
 }
 
-func parseArgs(args []string) (verbose bool, err error) {
-	for _, arg := range args {
-		switch {
-		case arg == "--verbose" || arg == "-v":
-			verbose = true
-		case arg == "--help" || arg == "-h":
-			return false, errHelp
-		default:
-			return false, fmt.Errorf("unknown argument: %s", arg)
+// summary counts what happened to each branch a run looked at. Every branch
+// is counted exactly once.
+type summary struct {
+	Branches  int `json:"branches"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Kept      int `json:"kept"`     // protected, or merged but the delete policy is never
+	Unpushed  int `json:"unpushed"` // ahead of or diverged from the remote
+	Unmerged  int `json:"unmerged"` // gone from the remote without being merged
+	Skipped   int `json:"skipped"`  // would have changed, but was in use
+	UpToDate  int `json:"upToDate"`
+	Untracked int `json:"untracked"` // nothing to compare with on the remote
+}
+
+// String summarises the counts in one line, leaving out those that are
+// zero, e.g. "12 branches: 3 updated, 2 deleted, 1 unpushed, 6 up to date".
+func (s summary) String() string {
+	var parts []string
+	for _, c := range []struct {
+		n     int
+		label string
+	}{
+		{s.Updated, "updated"},
+		{s.Deleted, "deleted"},
+		{s.Kept, "kept"},
+		{s.Unpushed, "unpushed"},
+		{s.Unmerged, "not merged"},
+		{s.Skipped, "skipped"},
+		{s.UpToDate, "up to date"},
+		{s.Untracked, "untracked"},
+	} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.label))
 		}
 	}
 
-	return verbose, nil
+	line := fmt.Sprintf("%d branches", s.Branches)
+	if s.Branches == 1 {
+		line = "1 branch"
+	}
+	if len(parts) > 0 {
+		line += ": " + strings.Join(parts, ", ")
+	}
+	return line
 }
 
-func printUsage(w io.Writer) {
-	fmt.Fprintln(w, `Usage: gh sync [flags]
+// branchReport records the outcome for a single branch.
+type branchReport struct {
+	Branch  string `json:"branch"`
+	Action  string `json:"action"` // "updated", "deleted", "kept" or "warning"
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Message string `json:"message,omitempty"`
+}
 
-Fetch from the primary remote and update local branches.
+// options holds the settings for a run, merged from the config file, git
+// config and the command line.
+type options struct {
+	verbose           bool
+	logFile           string // where to write a JSON trace of the run
+	timings           bool   // print time spent per git subcommand at the end
+	recurseSubmodules bool
+	noFetch           bool          // act on the existing remote-tracking refs
+	fetchTimeout      time.Duration // limit for each fetch attempt; zero means none
+	fetchRetries      int           // further fetch attempts after a transient failure
+	color             string        // color mode: auto, always or never
+	repo              string        // GH_REPO: sync with the remote pointing at this repository
+	noPrompt          bool          // GH_PROMPT_DISABLED or gh's prompt setting: never ask questions
+	remotes           []string      // preferred remotes, most preferred first
+	protect           []string      // glob patterns of branches never to delete
+	include           []string      // glob patterns of branches to process; empty means all
+	exclude           []string      // glob patterns of branches to skip
+	deletePolicy      string
+	format            string
+}
 
-If a local branch is outdated, fast-forward it.
-If a local branch contains unpushed work, warn about it.
-If a branch seems merged and its upstream was deleted, delete it.
+// fetchBackoff is how long to wait before retrying a failed fetch the first
+// time; each retry after that waits twice as long.
+const fetchBackoff = 2 * time.Second
 
-Flags:
-  --verbose, -v     Log each git command to stderr
-  -h, --help        Show this help`)
+// fetchOptions returns the settings for git.Fetch.
+func (o options) fetchOptions() git.FetchOptions {
+	return git.FetchOptions{Timeout: o.fetchTimeout, Retries: o.fetchRetries, Backoff: fetchBackoff}
 }
 
-func sync(stdout, stderr io.Writer, useColor bool) error {
-	var green, brightGreen, red, brightRed, reset string
-	if useColor {
-		green = "\033[32m"
-		brightGreen = "\033[1;32m"
-		red = "\033[31m"
-		brightRed = "\033[1;31m"
-		reset = "\033[0m"
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// exitCode maps the outcome of a sync run to the process exit status.
+func exitCode(res result, err error) int {
+	var coded *exitError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, git.ErrNotARepository), errors.Is(err, git.ErrNoRemotes), errors.Is(err, errNoRepoRemote):
+		return exitNoRepo
+	case err != nil:
+		return exitFailure
+	case res.warnings > 0:
+		return exitWarnings
+	}
+	return exitOK
+}
+
+// syncAll syncs the repository, then its submodules when asked to.
+func syncAll(ctx context.Context, stdout, stderr io.Writer, opts options) (result, error) {
+	res, err := sync(ctx, stdout, stderr, opts)
+	if err == nil && opts.recurseSubmodules {
+		var subRes result
+		subRes, err = syncSubmodules(ctx, stdout, stderr, opts)
+		res.warnings += subRes.warnings
+	}
+	return res, err
+}
+
+func sync(ctx context.Context, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
+
+	out, errOut := newTheme(opts.color, stdout), newTheme(opts.color, stderr)
+
+	// warn reports a branch that needs the user's attention
+	warn := func(branch, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		errOut.warnf(stderr, "%s", msg)
+		res.warnings++
+		res.branches = append(res.branches, branchReport{Branch: branch, Action: "warning", Message: msg})
 	}
 
-	// Find the main remote (upstream > github > origin)
-	remote, err := git.MainRemote()
+	// Work out where we are: a regular checkout, a linked worktree, a
+	// submodule, or a bare repository with no work tree at all
+	repo, err := git.Inspect(ctx)
 	if err != nil {
-		return err
+		return res, err
 	}
 
-	// Determine the default branch on that remote
-	defaultBranch := git.DefaultBranch(remote)
-	defaultRef := fmt.Sprintf("refs/remotes/%s/%s", remote, defaultBranch)
+	// Find the main remote (configured preference, then upstream > github > origin)
+	remote, err := mainRemote(ctx, opts)
+	if err != nil {
+		return res, err
+	}
+	res.remote = remote
 
-	// Note which branch we're on (empty string if detached HEAD)
-	currentBranch, _ := git.CurrentBranch()
+	// Note which branch we're on (empty string if detached HEAD, or if there
+	// is no work tree here to have anything checked out)
+	currentBranch, _ := git.CurrentBranch(ctx)
+	if !repo.WorkTree {
+		currentBranch = ""
+	}
 
-	// Fetch with pruning so deleted remote branches are cleaned up
-	if err := git.Fetch(remote); err != nil {
-		return fmt.Errorf("fetch failed: %w", err)
+	// Fetch with pruning so deleted remote branches are cleaned up, unless
+	// asked to work from the remote-tracking refs as they are
+	if !opts.noFetch {
+		if err := git.Fetch(ctx, remote, opts.fetchOptions()); err != nil {
+			return res, &exitError{code: exitFetchFailed, err: fmt.Errorf("fetch failed: %w", err)}
+		}
 	}
 
+	// Determine the default branch on that remote, now that the fetch has
+	// brought in its remote-tracking branch
+	defaultBranch := resolveDefaultBranch(ctx, stderr, opts, remote, !opts.noFetch)
+	defaultRef := fmt.Sprintf("refs/remotes/%s/%s", remote, defaultBranch)
+	res.defaultBranch = defaultBranch
+
 	// Read branch.*.remote config to know which branches explicitly track the remote
-	branchRemotes := git.BranchRemotes()
+	branchRemotes := git.BranchRemotes(ctx)
 
 	// Enumerate local branches
-	branches, err := git.LocalBranches()
+	branches, err := git.LocalBranches(ctx)
 	if err != nil {
-		return err
+		return res, err
 	}
+	branches = selectBranches(branches, opts.include, opts.exclude)
+
+	pc := &planContext{
+		remote:        remote,
+		defaultBranch: defaultBranch,
+		currentBranch: currentBranch,
+		opts:          opts,
+	}
+	if err := pc.checkedOut(ctx, repo); err != nil {
+		return res, err
+	}
+
+	logger.InfoContext(ctx, "sync", "remote", remote, "defaultBranch", defaultBranch,
+		"currentBranch", currentBranch, "branches", len(branches))
+
+	var changes []refChange
 
 	for _, branch := range branches {
-		localRef := fmt.Sprintf("refs/heads/%s", branch)
-		remoteRef := fmt.Sprintf("refs/remotes/%s/%s", remote, branch)
-		gone := false
-
-		if branchRemotes[branch] == remote {
-			// Branch is configured to track this remote.
-			// Try to resolve its upstream; if that fails the upstream was deleted.
-			if upstream, err := git.UpstreamRef(branch); err == nil {
-				remoteRef = upstream
-			} else {
-				remoteRef = ""
-				gone = true
-			}
-		} else if !git.HasRef(remoteRef) {
-			// No tracking config and no matching branch on the remote — skip it.
-			remoteRef = ""
+		// On Ctrl-C, stop before the next branch; nothing has changed yet.
+		if err := ctx.Err(); err != nil {
+			return res, err
 		}
 
-		if remoteRef != "" {
-			// The branch has a remote counterpart — compare them.
-			r, err := git.NewRange(localRef, remoteRef)
-			if err != nil {
-				return err
-			}
+		st, err := classifyBranch(ctx, branch, remote, defaultRef, branchRemotes, opts.deletePolicy)
+		if err != nil {
+			return res, err
+		}
 
-			if r.IsIdentical() {
-				continue
+		p := planBranch(ctx, branch, st, pc)
+		logDecision(ctx, branch, st.state, p.action, p.reason)
+		res.summary.Branches++
+
+		switch p.action {
+		case actionUpdate:
+			changes = append(changes, refChange{branch: branch, oldValue: st.r.A, newValue: st.r.B, worktree: p.worktree})
+		case actionDelete:
+			upstreamRemote, merge := git.BranchUpstream(ctx, branch)
+			changes = append(changes, refChange{branch: branch, oldValue: st.r.A, remote: upstreamRemote, merge: merge})
+		case actionKeep:
+			res.summary.Kept++
+			res.branches = append(res.branches, branchReport{Branch: branch, Action: "kept", Message: p.reason})
+		case actionWarn:
+			switch st.state {
+			case stateAhead, stateDiverged:
+				res.summary.Unpushed++
+			case stateGoneUnmerged:
+				res.summary.Unmerged++
+			default:
+				res.summary.Skipped++
 			}
-
-			if r.IsAncestor() {
-				// Local is behind — fast-forward.
-				if branch == currentBranch {
-					if err := git.MergeFFOnly(remoteRef); err != nil {
-						return fmt.Errorf("failed to fast-forward %s: %w", branch, err)
-					}
-				} else {
-					if err := git.UpdateRef(localRef, remoteRef); err != nil {
-						return fmt.Errorf("failed to update %s: %w", branch, err)
-					}
-				}
-				fmt.Fprintf(stdout, "%sUpdated branch %s%s%s (was %s).\n",
-					green, brightGreen, branch, reset, r.A[:7])
+			warn(branch, "%s", p.warning)
+		case actionNone:
+			if st.state == stateUntracked {
+				res.summary.Untracked++
 			} else {
-				fmt.Fprintf(stderr, "warning: '%s' seems to contain unpushed commits\n", branch)
+				res.summary.UpToDate++
 			}
-		} else if gone {
-			// The upstream branch was deleted from the remote.
-			r, err := git.NewRange(localRef, defaultRef)
-			if err != nil {
-				return err
+		}
+	}
+
+	// Whatever goes wrong from here on, report and remember the changes that
+	// were made before it did.
+	message := fmt.Sprintf("gh-sync: fast-forward from %s", remote)
+	planned := len(changes)
+	changes, err = applyChanges(ctx, changes, currentBranch, defaultBranch, message, warn)
+	if err == nil {
+		// A planned change that wasn't applied was a merge skipped with a warning.
+		res.summary.Skipped += planned - len(changes)
+	}
+
+	// Remember what changed so "gh sync undo" can put it back
+	if err := writeJournal(repo.CommonDir, changes); err != nil {
+		errOut.warnf(stderr, "could not record changes for undo: %s", err)
+	}
+
+	for _, c := range changes {
+		if c.isDelete() {
+			res.summary.Deleted++
+			logger.InfoContext(ctx, "deleted", "branch", c.branch, "from", c.oldValue)
+			res.branches = append(res.branches, branchReport{Branch: c.branch, Action: "deleted", From: c.oldValue})
+		} else {
+			res.summary.Updated++
+			logger.InfoContext(ctx, "updated", "branch", c.branch, "from", c.oldValue, "to", c.newValue)
+			res.branches = append(res.branches, branchReport{Branch: c.branch, Action: "updated", From: c.oldValue, To: c.newValue})
+		}
+
+		if opts.format == formatJSON {
+			continue
+		}
+		if c.isDelete() {
+			fmt.Fprintf(stdout, "%s%s (was %s).\n",
+				out.deleted("Deleted branch "), out.deletedBranch(c.branch), c.oldValue[:7])
+		} else {
+			fmt.Fprintf(stdout, "%s%s (was %s).\n",
+				out.updated("Updated branch "), out.updatedBranch(c.branch), c.oldValue[:7])
+		}
+	}
+
+	logger.InfoContext(ctx, "summary", "branches", res.summary.Branches, "updated", res.summary.Updated,
+		"deleted", res.summary.Deleted, "kept", res.summary.Kept, "unpushed", res.summary.Unpushed,
+		"unmerged", res.summary.Unmerged, "skipped", res.summary.Skipped, "upToDate", res.summary.UpToDate,
+		"untracked", res.summary.Untracked)
+
+	// A run that fails part way through has no meaningful totals to show;
+	// the error says what happened instead.
+	if opts.format != formatJSON && err == nil {
+		fmt.Fprintln(stdout, res.summary)
+	}
+
+	if opts.format == formatJSON {
+		if jsonErr := writeJSON(stdout, res); err == nil {
+			err = jsonErr
+		}
+	}
+	return res, err
+}
+
+// writeJSON prints the outcome of a run as a single JSON object. Submodules
+// synced with --recurse-submodules each get their own line, tagged with
+// their path.
+func writeJSON(w io.Writer, res result) error {
+	report := struct {
+		Path          string         `json:"path,omitempty"`
+		Remote        string         `json:"remote"`
+		DefaultBranch string         `json:"defaultBranch"`
+		Branches      []branchReport `json:"branches"`
+		Warnings      int            `json:"warnings"`
+		Summary       summary        `json:"summary"`
+	}{
+		Remote:        res.remote,
+		DefaultBranch: res.defaultBranch,
+		Branches:      res.branches,
+		Warnings:      res.warnings,
+		Summary:       res.summary,
+	}
+	if git.Dir != "" {
+		report.Path = displayPath(git.Dir)
+	}
+	if report.Branches == nil {
+		report.Branches = []branchReport{}
+	}
+	return json.NewEncoder(w).Encode(report)
+}
+
+// selectBranches keeps the branches that match an include pattern, or all of
+// them when there are none, minus any that match an exclude pattern.
+func selectBranches(branches, include, exclude []string) []string {
+	var selected []string
+	for _, branch := range branches {
+		if len(include) > 0 && !matchesAny(branch, include) {
+			continue
+		}
+		if matchesAny(branch, exclude) {
+			continue
+		}
+		selected = append(selected, branch)
+	}
+	return selected
+}
+
+// refChange is a planned fast-forward or deletion of a local branch.
+type refChange struct {
+	branch   string
+	oldValue string
+	newValue string // empty when the branch is to be deleted
+	worktree string // path of another worktree that has the branch checked out
+	remote   string // branch.<name>.remote of a deleted branch, for undo
+	merge    string // branch.<name>.merge of a deleted branch, for undo
+}
+
+func (c refChange) isDelete() bool {
+	return c.newValue == ""
+}
+
+// applyChanges carries out the planned changes as a single update-ref
+// transaction, so a run either moves every branch or none of them. It
+// returns the changes that were made, in plan order.
+//
+// Checked-out branches are the exception: their refs can't move underneath a
+// working tree. If the current branch is being deleted, the default branch is
+// checked out first, and the current branch checked out again should the
+// transaction fail; whichever branch ends up checked out, and any branch
+// checked out in another worktree, is fast-forwarded with a merge inside its
+// worktree after the transaction commits.
+//
+// A merge blocked by local changes, or by the branch moving on in the
+// meantime, is reported through warn and the branch is left alone.
+//
+// Once the checkout starts, it and the transaction complete even if ctx is
+// cancelled, so the current branch is never switched away from without being
+// deleted. Cancelling only skips the merges that haven't started yet.
+func applyChanges(ctx context.Context, changes []refChange, currentBranch, defaultBranch, message string,
+	warn func(branch, format string, args ...any)) ([]refChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	uninterrupted := context.WithoutCancel(ctx)
+
+	var switchedFrom string
+	for _, c := range changes {
+		if c.branch == currentBranch && c.isDelete() {
+			if err := git.Checkout(uninterrupted, defaultBranch); err != nil {
+				return nil, fmt.Errorf("failed to checkout %s: %w", defaultBranch, err)
 			}
+			switchedFrom, currentBranch = currentBranch, defaultBranch
+			break
+		}
+	}
 
-			shouldDelete := r.IsAncestor()
+	tx := git.NewTransaction(message)
+	var merge *refChange
+	var worktreeMerges []refChange
+	done := make(map[string]bool)
+	for i, c := range changes {
+		ref := "refs/heads/" + c.branch
+		switch {
+		case c.isDelete():
+			tx.Delete(ref, c.oldValue)
+			done[c.branch] = true
+		case c.branch == currentBranch:
+			merge = &changes[i]
+		case c.worktree != "":
+			worktreeMerges = append(worktreeMerges, c)
+		default:
+			tx.Update(ref, c.newValue, c.oldValue)
+			done[c.branch] = true
+		}
+	}
 
-			// If it wasn't a regular merge, check for a squash-merge.
-			if !shouldDelete {
-				shouldDelete = isSquashMerged(localRef, defaultRef, branch)
+	applied := func() []refChange {
+		var out []refChange
+		for _, c := range changes {
+			if done[c.branch] {
+				out = append(out, c)
 			}
+		}
+		return out
+	}
 
-			if shouldDelete {
-				if branch == currentBranch {
-					if err := git.Checkout(defaultBranch); err != nil {
-						return fmt.Errorf("failed to checkout %s: %w", defaultBranch, err)
-					}
-					currentBranch = defaultBranch
-				}
-				if err := git.DeleteBranch(branch); err != nil {
-					return fmt.Errorf("failed to delete %s: %w", branch, err)
-				}
-				fmt.Fprintf(stdout, "%sDeleted branch %s%s%s (was %s).\n",
-					red, brightRed, branch, reset, r.A[:7])
-			} else {
-				fmt.Fprintf(stderr, "warning: '%s' was deleted on %s, but appears not merged into '%s'\n",
-					branch, remote, defaultBranch)
+	if err := tx.Commit(uninterrupted); err != nil {
+		// Nothing was deleted, so go back to the branch we switched away from.
+		if switchedFrom != "" {
+			if cerr := git.Checkout(uninterrupted, switchedFrom); cerr != nil {
+				return nil, fmt.Errorf("no branches were changed, but %s is checked out instead of %s (%v): %w",
+					defaultBranch, switchedFrom, cerr, err)
 			}
 		}
+		return nil, fmt.Errorf("no branches were changed: %w", err)
+	}
+
+	for _, c := range changes {
+		if c.isDelete() {
+			git.RemoveBranchConfig(uninterrupted, c.branch)
+		}
+	}
+
+	if merge != nil {
+		err := git.MergeFFOnly(ctx, merge.newValue)
+		switch reason := mergeSkipReason(err); {
+		case reason != "":
+			warn(merge.branch, "'%s' was not fast-forwarded: %s", merge.branch, reason)
+		case err != nil:
+			return applied(), fmt.Errorf("failed to fast-forward %s: %w", merge.branch, err)
+		default:
+			done[merge.branch] = true
+		}
+	}
+
+	for _, c := range worktreeMerges {
+		err := git.MergeFFOnlyAt(ctx, c.worktree, c.newValue)
+		switch reason := mergeSkipReason(err); {
+		case reason != "":
+			warn(c.branch, "'%s' in %s was not fast-forwarded: %s", c.branch, c.worktree, reason)
+		case err != nil:
+			return applied(), fmt.Errorf("failed to fast-forward %s in %s: %w", c.branch, c.worktree, err)
+		default:
+			done[c.branch] = true
+		}
 	}
 
-	return nil
+	return applied(), nil
 }
 
-// isSquashMerged detects whether a branch was squash-merged into the target.
-//
-// The trick: create a temporary commit whose tree matches the branch tip, parented
-// at the merge-base of the two branches. Then ask git cherry whether that diff
-// already exists in the target. A "-" prefix means the patch was already applied,
-// which is exactly what a squash-merge looks like.
-func isSquashMerged(branchRef, targetRef, branchName string) bool {
-	ancestor, err := git.MergeBase(targetRef, branchRef)
+// mergeSkipReason explains a fast-forward merge that failed because of the
+// state of the worktree rather than anything wrong with the run, so the
+// branch can be skipped with a warning. It returns "" for any other error.
+func mergeSkipReason(err error) string {
+	switch {
+	case errors.Is(err, git.ErrDirtyWorktree):
+		return "local changes would be overwritten"
+	case errors.Is(err, git.ErrNonFastForward):
+		return "it gained new commits during the run"
+	}
+	return ""
+}
+
+// syncSubmodules runs sync inside each initialized submodule of the
+// repository in git.Dir, then recurses into their own submodules. A failure
+// in one submodule is reported and the rest are still synced. GH_REPO names
+// the superproject's repository, so submodules pick their own remote.
+func syncSubmodules(ctx context.Context, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
+	opts.repo = ""
+
+	paths, err := git.Submodules(ctx)
 	if err != nil {
+		return res, err
+	}
+
+	parent := git.Dir
+	defer func() { git.Dir = parent }()
+
+	var failed []string
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		name := displayPath(path)
+		if opts.format != formatJSON {
+			fmt.Fprintf(stdout, "Entering '%s'\n", name)
+		}
+		git.Dir = path
+
+		subRes, err := sync(ctx, stdout, stderr, opts)
+		if err == nil {
+			var nestedRes result
+			nestedRes, err = syncSubmodules(ctx, stdout, stderr, opts)
+			subRes.warnings += nestedRes.warnings
+		}
+		res.warnings += subRes.warnings
+
+		if err != nil {
+			newTheme(opts.color, stderr).errorf(stderr, "%s: %s", name, err)
+			failed = append(failed, name)
+		}
+		git.Dir = parent
+	}
+
+	if len(failed) > 0 {
+		return res, fmt.Errorf("failed to sync submodules: %s", strings.Join(failed, ", "))
+	}
+	return res, nil
+}
+
+// displayPath shows path relative to the working directory.
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil {
+			return rel
+		}
+	}
+	return path
+}
+
+// isMerged reports whether the branch in r.A has landed in r.B, either as a
+// regular merge or, unless the deletion policy is strict, as a squash-merge.
+func isMerged(ctx context.Context, r *git.Range, branchRef, targetRef, policy string) bool {
+	if r.IsAncestor(ctx) {
+		trace(ctx, "%s is an ancestor of %s, so it was merged", branchRef, targetRef)
+		return true
+	}
+	trace(ctx, "%s is not an ancestor of %s", branchRef, targetRef)
+	if policy == deleteStrict {
+		trace(ctx, "the delete policy is strict, so squash-merges don't count")
 		return false
 	}
+	return isSquashMerged(ctx, branchRef, targetRef)
+}
 
-	tree, err := git.TreeHash(branchRef)
+// isSquashMerged detects whether a branch was squash-merged into the target.
+//
+// A squash-merge lands everything the branch changed since the merge-base
+// as one commit, so the branch's whole diff since the merge-base has the
+// same patch ID as a commit on the target since then. Comparing patch IDs
+// writes nothing, so status and explain can run it freely.
+func isSquashMerged(ctx context.Context, branchRef, targetRef string) bool {
+	ancestor, err := git.MergeBase(ctx, targetRef, branchRef)
 	if err != nil {
+		trace(ctx, "no merge-base with %s, so it can't have been squash-merged", targetRef)
 		return false
 	}
+	trace(ctx, "merge-base with %s is %s", targetRef, ancestor[:7])
 
-	dangling, err := git.CommitTree(tree, ancestor, fmt.Sprintf("temp squash-merge check for %s", branchName))
+	id, err := git.DiffPatchID(ctx, ancestor, branchRef)
 	if err != nil {
+		trace(ctx, "could not diff %s against the merge-base: %s", branchRef, err)
 		return false
 	}
+	if id == "" {
+		trace(ctx, "%s changes nothing since the merge-base", branchRef)
+		return false
+	}
+	trace(ctx, "its changes since the merge-base have patch ID %s", id[:7])
 
-	result, err := git.Cherry(targetRef, dangling)
+	commits, err := git.CommitPatchIDs(ctx, ancestor, targetRef)
 	if err != nil {
+		trace(ctx, "could not read the patch IDs on %s: %s", targetRef, err)
 		return false
 	}
-
-	return strings.HasPrefix(result, "-")
+	if commit, ok := commits[id]; ok {
+		trace(ctx, "commit %s on %s has the same patch ID, so it was squash-merged", commit[:7], targetRef)
+		return true
+	}
+	trace(ctx, "no commit on %s since the merge-base has that patch ID", targetRef)
+	return false
 }
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -16,53 +20,54 @@ import (
 // Unit tests
 // ---------------------------------------------------------------------------
 
-func TestParseArgs(t *testing.T) {
+func TestExitCode(t *testing.T) {
 	tests := []struct {
-		name    string
-		args    []string
-		verbose bool
-		wantErr bool
-		isHelp  bool
+		name string
+		res  result
+		err  error
+		want int
 	}{
-		{"no flags", nil, false, false, false},
-		{"verbose long", []string{"--verbose"}, true, false, false},
-		{"verbose short", []string{"-v"}, true, false, false},
-		{"unknown flag", []string{"--unknown"}, false, true, false},
-		{"help long", []string{"--help"}, false, true, true},
-		{"help short", []string{"-h"}, false, true, true},
+		{"clean", result{}, nil, exitOK},
+		{"warnings", result{warnings: 2}, nil, exitWarnings},
+		{"plain error", result{}, errors.New("boom"), exitFailure},
+		{"coded error", result{}, &exitError{code: exitFetchFailed, err: errors.New("boom")}, exitFetchFailed},
+		{"wrapped coded error", result{}, fmt.Errorf("ctx: %w", &exitError{code: exitNoRepo, err: errors.New("boom")}), exitNoRepo},
+		{"not a repository", result{}, git.ErrNotARepository, exitNoRepo},
+		{"no remotes", result{}, fmt.Errorf("ctx: %w", git.ErrNoRemotes), exitNoRepo},
+		{"error wins over warnings", result{warnings: 1}, errors.New("boom"), exitFailure},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			verbose, err := parseArgs(tt.args)
-			if tt.isHelp {
-				if err != errHelp {
-					t.Fatalf("parseArgs(%v) error = %v, want errHelp", tt.args, err)
-				}
-				return
-			}
-			if (err != nil) != tt.wantErr {
-				t.Fatalf("parseArgs(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
-			}
-			if err != nil {
-				return
-			}
-			if verbose != tt.verbose {
-				t.Errorf("verbose = %v, want %v", verbose, tt.verbose)
+			if got := exitCode(tt.res, tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestPrintUsage(t *testing.T) {
-	var buf bytes.Buffer
-	printUsage(&buf)
+func TestSelectBranches(t *testing.T) {
+	branches := []string{"main", "develop", "feature/a", "feature/b", "wip"}
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+	}{
+		{"no filters", nil, nil, branches},
+		{"include names", []string{"main", "develop"}, nil, []string{"main", "develop"}},
+		{"include glob", []string{"feature/*"}, nil, []string{"feature/a", "feature/b"}},
+		{"exclude glob", nil, []string{"feature/*"}, []string{"main", "develop", "wip"}},
+		{"exclude wins", []string{"feature/*"}, []string{"feature/b"}, []string{"feature/a"}},
+		{"nothing matches", []string{"release/*"}, nil, nil},
+	}
 
-	output := buf.String()
-	for _, want := range []string{"--verbose", "--help", "gh sync"} {
-		if !strings.Contains(output, want) {
-			t.Errorf("usage output missing %q", want)
-		}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectBranches(branches, tt.include, tt.exclude)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectBranches() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
 
@@ -100,19 +105,25 @@ func newTestEnv(t *testing.T) *testEnv {
 
 // chdir changes into the local repo and restores the original dir on cleanup.
 func (e *testEnv) chdir() {
+	e.t.Helper()
+	e.chdirTo(e.local)
+}
+
+// chdirTo changes into dir and restores the original dir on cleanup.
+func (e *testEnv) chdirTo(dir string) {
 	e.t.Helper()
 	orig, err := os.Getwd()
 	if err != nil {
 		e.t.Fatal(err)
 	}
-	if err := os.Chdir(e.local); err != nil {
+	if err := os.Chdir(dir); err != nil {
 		e.t.Fatal(err)
 	}
 	e.t.Cleanup(func() { os.Chdir(orig) })
 
-	git.Verbose = false
-	git.Color = false
+	closeLogging()
 	git.Stderr = os.Stderr
+	git.Dir = ""
 }
 
 var tmpCounter int
@@ -176,9 +187,14 @@ func (e *testEnv) squashMergeOnRemote(branch string) {
 }
 
 func runSync(t *testing.T) (stdout, stderr string, err error) {
+	t.Helper()
+	return runSyncWith(t, options{})
+}
+
+func runSyncWith(t *testing.T, opts options) (stdout, stderr string, err error) {
 	t.Helper()
 	var outBuf, errBuf bytes.Buffer
-	err = sync(&outBuf, &errBuf, false)
+	_, err = sync(t.Context(), &outBuf, &errBuf, opts)
 	return outBuf.String(), errBuf.String(), err
 }
 
@@ -192,8 +208,69 @@ func TestSync_UpToDate(t *testing.T) {
 	if err != nil {
 		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
 	}
-	if stdout != "" {
-		t.Errorf("expected no stdout for up-to-date repo, got: %s", stdout)
+	if stdout != "1 branch: 1 up to date\n" {
+		t.Errorf("expected only the summary for up-to-date repo, got: %s", stdout)
+	}
+}
+
+func TestSync_Summary(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("behind", "behind.txt", "v1\n")
+	env.addRemoteCommit("behind", "behind.txt", "v2\n")
+	env.createBranch("landed", "landed.txt", "landed\n")
+	env.mergeOnRemote("landed")
+	env.deleteRemoteBranch("landed")
+	env.createBranch("abandoned", "abandoned.txt", "abandoned\n")
+	env.deleteRemoteBranch("abandoned")
+	mustExec(t, env.local, "git", "branch", "local-only")
+	env.chdir()
+
+	var stdout, stderr bytes.Buffer
+	res, err := sync(t.Context(), &stdout, &stderr, options{})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr.String())
+	}
+
+	want := summary{Branches: 5, Updated: 2, Deleted: 1, Unmerged: 1, Untracked: 1}
+	if res.summary != want {
+		t.Errorf("summary = %+v, want %+v", res.summary, want)
+	}
+	line := "5 branches: 2 updated, 1 deleted, 1 not merged, 1 untracked\n"
+	if !strings.HasSuffix(stdout.String(), line) {
+		t.Errorf("expected stdout to end with %q, got: %s", line, stdout.String())
+	}
+}
+
+func TestSync_ResolvesDefaultBranch(t *testing.T) {
+	env := newTestEnv(t)
+	// The remote's default is develop, but the clone has no HEAD ref to say so.
+	mustExec(t, env.remote, "git", "branch", "develop", "main")
+	mustExec(t, env.remote, "git", "symbolic-ref", "HEAD", "refs/heads/develop")
+	mustExec(t, env.local, "git", "remote", "set-head", "origin", "--delete")
+	env.chdir()
+
+	var stdout, stderr bytes.Buffer
+	res, err := sync(t.Context(), &stdout, &stderr, options{noFetch: true})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr.String())
+	}
+	if res.defaultBranch != "main" || !strings.Contains(stderr.String(), "could not confirm the default branch of origin; assuming 'main'") {
+		t.Errorf("with --no-fetch, default branch = %q, stderr = %q; want a warned guess of main", res.defaultBranch, stderr.String())
+	}
+
+	stderr.Reset()
+	res, err = sync(t.Context(), &stdout, &stderr, options{})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr.String())
+	}
+	if res.defaultBranch != "develop" {
+		t.Errorf("default branch = %q, want %q", res.defaultBranch, "develop")
+	}
+	if strings.Contains(stderr.String(), "could not confirm") {
+		t.Errorf("unexpected warning: %s", stderr.String())
+	}
+	if head := mustExec(t, env.local, "git", "symbolic-ref", "refs/remotes/origin/HEAD"); strings.TrimSpace(head) != "refs/remotes/origin/develop" {
+		t.Errorf("refs/remotes/origin/HEAD = %q, want it to record develop", head)
 	}
 }
 
@@ -242,10 +319,15 @@ func TestSync_DivergedBranch(t *testing.T) {
 
 	env.chdir()
 
-	stdout, stderr, err := runSync(t)
+	var outBuf, errBuf bytes.Buffer
+	res, err := sync(t.Context(), &outBuf, &errBuf, options{})
+	stdout, stderr := outBuf.String(), errBuf.String()
 	if err != nil {
 		t.Fatalf("sync error: %v\nstdout: %s", err, stdout)
 	}
+	if code := exitCode(res, err); code != exitWarnings {
+		t.Errorf("exitCode() = %d, want %d", code, exitWarnings)
+	}
 	if !strings.Contains(stderr, "unpushed commits") {
 		t.Errorf("expected unpushed warning, got stderr: %s", stderr)
 	}
@@ -273,6 +355,13 @@ func TestSync_DeleteMergedBranch(t *testing.T) {
 	if strings.TrimSpace(out) != "" {
 		t.Error("branch merged-feature should have been deleted")
 	}
+
+	// Its tracking config should be gone too, as with "git branch -D"
+	cmd := exec.Command("git", "config", "--get", "branch.merged-feature.remote")
+	cmd.Dir = env.local
+	if out, err := cmd.Output(); err == nil {
+		t.Errorf("branch.merged-feature.remote should have been removed, got %q", out)
+	}
 }
 
 func TestSync_DeleteSquashMergedBranch(t *testing.T) {
@@ -332,7 +421,7 @@ func TestSync_DeleteCurrentBranch(t *testing.T) {
 	}
 
 	// Verify we switched to the default branch
-	branch, err := git.CurrentBranch()
+	branch, err := git.CurrentBranch(t.Context())
 	if err != nil {
 		t.Fatalf("CurrentBranch() error: %v", err)
 	}
@@ -369,13 +458,375 @@ func TestSync_ImplicitUpstream(t *testing.T) {
 	}
 }
 
+func TestSync_FastForwardWorktreeBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("wt-feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("wt-feature", "feature.txt", "v2\n")
+
+	wt := filepath.Join(env.base, "wt")
+	mustExec(t, env.local, "git", "worktree", "add", wt, "wt-feature")
+
+	env.chdir()
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Updated branch wt-feature") {
+		t.Errorf("expected update message for wt-feature, got stdout: %s", stdout)
+	}
+
+	// The worktree's files must follow the branch, not just the ref.
+	content, err := os.ReadFile(filepath.Join(wt, "feature.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2\n" {
+		t.Errorf("worktree feature.txt = %q, want %q", content, "v2\n")
+	}
+	if status := mustExec(t, wt, "git", "status", "--porcelain"); status != "" {
+		t.Errorf("worktree should be clean after sync, got:\n%s", status)
+	}
+}
+
+func TestSync_DirtyWorktreeBranchSkipped(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("wt-dirty", "feature.txt", "v1\n")
+	env.addRemoteCommit("wt-dirty", "feature.txt", "v2\n")
+
+	wt := filepath.Join(env.base, "wt")
+	mustExec(t, env.local, "git", "worktree", "add", wt, "wt-dirty")
+	writeTestFile(t, filepath.Join(wt, "feature.txt"), "work in progress\n")
+
+	env.chdir()
+	before := mustExec(t, env.local, "git", "rev-parse", "wt-dirty")
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "wt-dirty") {
+		t.Errorf("dirty worktree branch should not be updated, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stderr, "uncommitted changes") {
+		t.Errorf("expected dirty worktree warning, got stderr: %s", stderr)
+	}
+	if after := mustExec(t, env.local, "git", "rev-parse", "wt-dirty"); after != before {
+		t.Errorf("wt-dirty moved from %s to %s", before, after)
+	}
+}
+
+func TestSync_KeepsMergedWorktreeBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("wt-merged", "merged.txt", "content\n")
+	env.mergeOnRemote("wt-merged")
+	env.deleteRemoteBranch("wt-merged")
+
+	wt := filepath.Join(env.base, "wt")
+	mustExec(t, env.local, "git", "worktree", "add", wt, "wt-merged")
+
+	env.chdir()
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Deleted branch wt-merged") {
+		t.Errorf("branch checked out in a worktree must not be deleted, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stderr, "checked out in") {
+		t.Errorf("expected worktree warning, got stderr: %s", stderr)
+	}
+}
+
+// bareWithWorktrees clones the remote as a bare repository with a fetch
+// refspec, and checks out main and feature in linked worktrees beside it.
+func (e *testEnv) bareWithWorktrees() (bare, mainWT, featureWT string) {
+	e.t.Helper()
+	bare = filepath.Join(e.base, "bare.git")
+	mainWT = filepath.Join(e.base, "wt-main")
+	featureWT = filepath.Join(e.base, "wt-feature")
+
+	mustExec(e.t, "", "git", "clone", "--bare", e.remote, bare)
+	mustExec(e.t, bare, "git", "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	mustExec(e.t, bare, "git", "fetch", "--quiet", "origin")
+	mustExec(e.t, bare, "git", "worktree", "add", mainWT, "main")
+	mustExec(e.t, bare, "git", "worktree", "add", featureWT, "feature")
+	return bare, mainWT, featureWT
+}
+
+func TestSync_FromBareRepository(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	bare, mainWT, _ := env.bareWithWorktrees()
+
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdirTo(bare)
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	for _, want := range []string{"Updated branch main", "Updated branch feature"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q, got stdout: %s\nstderr: %s", want, stdout, stderr)
+		}
+	}
+
+	// main is checked out in a worktree, so its files must follow the ref.
+	if _, err := os.Stat(filepath.Join(mainWT, "new.txt")); err != nil {
+		t.Errorf("expected new.txt in main worktree: %v", err)
+	}
+}
+
+func TestSync_FromLinkedWorktree(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	_, mainWT, featureWT := env.bareWithWorktrees()
+
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdirTo(featureWT)
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Updated branch feature") {
+		t.Errorf("expected update message for feature, got stdout: %s", stdout)
+	}
+
+	content, err := os.ReadFile(filepath.Join(featureWT, "feature.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2\n" {
+		t.Errorf("feature.txt = %q, want %q", content, "v2\n")
+	}
+	if _, err := os.Stat(filepath.Join(mainWT, "new.txt")); err != nil {
+		t.Errorf("expected new.txt in main worktree: %v", err)
+	}
+}
+
+func TestSync_KeepsBareHeadBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	bare, _, featureWT := env.bareWithWorktrees()
+
+	// Point the bare HEAD at a merged branch whose upstream is then deleted.
+	mustExec(t, featureWT, "git", "branch", "old-head", "main")
+	mustExec(t, bare, "git", "config", "branch.old-head.remote", "origin")
+	mustExec(t, bare, "git", "config", "branch.old-head.merge", "refs/heads/old-head")
+	mustExec(t, bare, "git", "symbolic-ref", "HEAD", "refs/heads/old-head")
+	env.chdirTo(bare)
+
+	stdout, stderr, err := runSync(t)
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Deleted branch old-head") {
+		t.Errorf("bare HEAD branch must not be deleted, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stderr, "HEAD of the bare repository") {
+		t.Errorf("expected bare HEAD warning, got stderr: %s", stderr)
+	}
+}
+
+func TestSyncSubmodules(t *testing.T) {
+	env := newTestEnv(t)
+
+	// A second repository serves as the submodule's remote.
+	lib := newTestEnv(t)
+	lib.createBranch("feature", "lib.txt", "v1\n")
+
+	mustExec(t, env.local, "git", "-c", "protocol.file.allow=always",
+		"submodule", "add", "--quiet", lib.remote, "vendor/lib")
+	mustExec(t, env.local, "git", "commit", "-m", "add submodule")
+
+	sub := filepath.Join(env.local, "vendor", "lib")
+	mustExec(t, sub, "git", "checkout", "--quiet", "-b", "feature", "origin/feature")
+	mustExec(t, sub, "git", "checkout", "--quiet", "--detach")
+	lib.addRemoteCommit("feature", "lib.txt", "v2\n")
+
+	env.chdir()
+
+	// GH_REPO names the superproject, which no submodule remote points at.
+	var outBuf, errBuf bytes.Buffer
+	_, err := syncSubmodules(t.Context(), &outBuf, &errBuf, options{repo: "acme/widget"})
+	if err != nil {
+		t.Fatalf("syncSubmodules error: %v\nstderr: %s", err, errBuf.String())
+	}
+
+	stdout := outBuf.String()
+	if !strings.Contains(stdout, "Entering 'vendor/lib'") {
+		t.Errorf("expected submodule header, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stdout, "Updated branch feature") {
+		t.Errorf("expected update message inside submodule, got stdout: %s", stdout)
+	}
+	if git.Dir != "" {
+		t.Errorf("git.Dir = %q after syncSubmodules, want it restored", git.Dir)
+	}
+}
+
+func TestSync_DeletePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       options
+		wantDelete bool
+	}{
+		{"merged deletes squash merges", options{deletePolicy: deleteMerged}, true},
+		{"strict keeps squash merges", options{deletePolicy: deleteStrict}, false},
+		{"never keeps everything", options{deletePolicy: deleteNever}, false},
+		{"protected pattern keeps branch", options{deletePolicy: deleteMerged, protect: []string{"squash/*"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.createBranch("squash/me", "squash.txt", "squash content\n")
+			env.squashMergeOnRemote("squash/me")
+			env.deleteRemoteBranch("squash/me")
+			env.chdir()
+
+			stdout, stderr, err := runSyncWith(t, tt.opts)
+			if err != nil {
+				t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+			}
+
+			deleted := strings.Contains(stdout, "Deleted branch squash/me")
+			if deleted != tt.wantDelete {
+				t.Errorf("deleted = %v, want %v\nstdout: %s\nstderr: %s", deleted, tt.wantDelete, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestSync_ProtectedBranchNotUpdated(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("release/1.0", "release.txt", "1.0\n")
+	env.addRemoteCommit("release/1.0", "release.txt", "1.0.1\n")
+	env.chdir()
+
+	before := mustExec(t, env.local, "git", "rev-parse", "release/1.0")
+
+	var outBuf, errBuf bytes.Buffer
+	res, err := sync(t.Context(), &outBuf, &errBuf, options{protect: []string{"release/*"}})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, errBuf.String())
+	}
+	if got := mustExec(t, env.local, "git", "rev-parse", "release/1.0"); got != before {
+		t.Errorf("protected branch moved from %s to %s", before, got)
+	}
+	if res.summary.Kept != 1 || res.summary.Updated != 0 {
+		t.Errorf("summary = %+v, want release/1.0 kept and nothing updated", res.summary)
+	}
+}
+
+func TestSync_ProtectedBranchKept(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("release/1.0", "release.txt", "1.0\n")
+	env.createBranch("merged-feature", "merged.txt", "content\n")
+	env.mergeOnRemote("release/1.0")
+	env.mergeOnRemote("merged-feature")
+	env.deleteRemoteBranch("release/1.0")
+	env.deleteRemoteBranch("merged-feature")
+	env.chdir()
+
+	stdout, stderr, err := runSyncWith(t, options{protect: []string{"release/*"}})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "release/1.0") {
+		t.Errorf("protected branch should be kept, got stdout: %s", stdout)
+	}
+	if !strings.Contains(stdout, "Deleted branch merged-feature") {
+		t.Errorf("unprotected branch should still be deleted, got stdout: %s", stdout)
+	}
+	if !git.HasRef(t.Context(), "refs/heads/release/1.0") {
+		t.Error("release/1.0 should still exist")
+	}
+}
+
+func TestSync_BranchFilters(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.createBranch("not-merged", "unique.txt", "unique\n")
+	env.deleteRemoteBranch("not-merged")
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdir()
+
+	stdout, stderr, err := runSyncWith(t, options{include: []string{"main", "not-*"}, exclude: []string{"not-merged"}})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Updated branch main") {
+		t.Errorf("expected main to be updated, got stdout: %s", stdout)
+	}
+	if strings.Contains(stdout, "feature") {
+		t.Errorf("feature is not included and should be left alone, got stdout: %s", stdout)
+	}
+	if stderr != "" {
+		t.Errorf("excluded branch should not be warned about, got stderr: %s", stderr)
+	}
+}
+
+func TestSync_JSONOutput(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("diverged", "file.txt", "original\n")
+	env.addRemoteCommit("diverged", "remote.txt", "from remote\n")
+	mustExec(t, env.local, "git", "checkout", "diverged")
+	writeTestFile(t, filepath.Join(env.local, "local.txt"), "local change\n")
+	mustExec(t, env.local, "git", "add", ".")
+	mustExec(t, env.local, "git", "commit", "-m", "local change")
+	mustExec(t, env.local, "git", "checkout", "main")
+	env.addRemoteCommit("main", "new.txt", "new\n")
+	env.chdir()
+
+	stdout, stderr, err := runSyncWith(t, options{format: formatJSON})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+
+	var report struct {
+		Remote        string         `json:"remote"`
+		DefaultBranch string         `json:"defaultBranch"`
+		Branches      []branchReport `json:"branches"`
+		Warnings      int            `json:"warnings"`
+		Summary       summary        `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+
+	if report.Remote != "origin" || report.DefaultBranch != "main" {
+		t.Errorf("remote = %q, defaultBranch = %q, want origin and main", report.Remote, report.DefaultBranch)
+	}
+	if report.Warnings != 1 {
+		t.Errorf("warnings = %d, want 1", report.Warnings)
+	}
+	if want := (summary{Branches: 2, Updated: 1, Unpushed: 1}); report.Summary != want {
+		t.Errorf("summary = %+v, want %+v", report.Summary, want)
+	}
+
+	actions := map[string]string{}
+	for _, b := range report.Branches {
+		actions[b.Branch] = b.Action
+	}
+	if actions["main"] != "updated" || actions["diverged"] != "warning" {
+		t.Errorf("actions = %v, want main updated and diverged warning", actions)
+	}
+}
+
 func TestSync_ColorOutput(t *testing.T) {
 	env := newTestEnv(t)
 	env.addRemoteCommit("main", "new.txt", "content\n")
 	env.chdir()
 
 	var stdout, stderr bytes.Buffer
-	err := sync(&stdout, &stderr, true)
+	_, err := sync(t.Context(), &stdout, &stderr, options{color: colorAlways})
 	if err != nil {
 		t.Fatalf("sync error: %v", err)
 	}
@@ -389,18 +840,137 @@ func TestSync_VerboseOutput(t *testing.T) {
 	env := newTestEnv(t)
 	env.chdir()
 
+	env.addRemoteCommit("main", "new.txt", "new\n")
+
 	var stdout, stderr bytes.Buffer
-	git.Verbose = true
-	git.Color = false
 	git.Stderr = &stderr
+	opts := options{verbose: true}
+	if err := setupLogging(&stderr, opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeLogging)
 
-	err := sync(&stdout, &stderr, false)
+	_, err := sync(t.Context(), &stdout, &stderr, opts)
 	if err != nil {
 		t.Fatalf("sync error: %v", err)
 	}
 
-	if !strings.Contains(stderr.String(), "$ git") {
-		t.Errorf("expected verbose git commands on stderr, got: %q", stderr.String())
+	for _, want := range []string{"$ git fetch", "decision branch=main state=behind action=update"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected %q in verbose output, got: %q", want, stderr.String())
+		}
+	}
+}
+
+func TestSync_DirtyCurrentBranchWarns(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.addRemoteCommit("main", "README.md", "# from remote\n")
+
+	// An uncommitted edit to the file the remote changed blocks the merge.
+	writeTestFile(t, filepath.Join(env.local, "README.md"), "# local edit\n")
+	env.chdir()
+
+	var outBuf, errBuf bytes.Buffer
+	res, err := sync(t.Context(), &outBuf, &errBuf, options{})
+	if err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, errBuf.String())
+	}
+	if code := exitCode(res, err); code != exitWarnings {
+		t.Errorf("exitCode() = %d, want %d", code, exitWarnings)
+	}
+	if !strings.Contains(errBuf.String(), "'main' was not fast-forwarded: local changes would be overwritten") {
+		t.Errorf("expected a warning about main, got stderr: %s", errBuf.String())
+	}
+	if stdout := outBuf.String(); !strings.Contains(stdout, "Updated branch feature") || strings.Contains(stdout, "Updated branch main") {
+		t.Errorf("expected only feature to be updated, got stdout: %s", stdout)
+	}
+}
+
+func TestSync_ReportsGitStderr(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+
+	// A lock left behind by a crashed git process blocks the update.
+	writeTestFile(t, filepath.Join(env.local, ".git", "refs", "heads", "feature.lock"), "")
+	env.chdir()
+
+	_, _, err := runSync(t)
+	if err == nil {
+		t.Fatal("expected sync to fail on the locked ref")
+	}
+	var gitErr *git.Error
+	if !errors.As(err, &gitErr) {
+		t.Fatalf("error = %v, want a *git.Error in the chain", err)
+	}
+	if !strings.Contains(err.Error(), "feature.lock': File exists") {
+		t.Errorf("error should explain the lock file, got: %s", err)
+	}
+}
+
+func TestSync_DeleteCurrentBranchFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("current-gone", "gone.txt", "gone\n")
+	env.mergeOnRemote("current-gone")
+	env.deleteRemoteBranch("current-gone")
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	mustExec(t, env.local, "git", "checkout", "current-gone")
+
+	// The lock makes the transaction fail after main has been checked out.
+	writeTestFile(t, filepath.Join(env.local, ".git", "refs", "heads", "feature.lock"), "")
+	env.chdir()
+
+	_, stderr, err := runSync(t)
+	if err == nil {
+		t.Fatalf("expected sync to fail on the locked ref\nstderr: %s", stderr)
+	}
+	if !strings.Contains(err.Error(), "no branches were changed") {
+		t.Errorf("error = %v, want it to say no branches were changed", err)
+	}
+
+	branch, err := git.CurrentBranch(t.Context())
+	if err != nil {
+		t.Fatalf("CurrentBranch() error: %v", err)
+	}
+	if branch != "current-gone" {
+		t.Errorf("expected to be back on current-gone, got %s", branch)
+	}
+	if !git.HasRef(t.Context(), "refs/heads/current-gone") {
+		t.Error("current-gone should not have been deleted")
+	}
+}
+
+func TestApplyChanges_Cancelled(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	mustExec(t, env.local, "git", "fetch", "--quiet", "origin")
+	env.chdir()
+
+	r, err := git.NewRange(t.Context(), "refs/heads/feature", "refs/remotes/origin/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	changes := []refChange{{branch: "feature", oldValue: r.A, newValue: r.B}}
+	warn := func(branch, format string, args ...any) { t.Errorf("unexpected warning for %s", branch) }
+	applied, err := applyChanges(ctx, changes, "main", "main", "gh-sync: test", warn)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("applyChanges() error = %v, want context.Canceled", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied = %v, want none", applied)
+	}
+
+	shas, err := git.RevParse(t.Context(), "refs/heads/feature")
+	if err != nil || shas[0] != r.A {
+		t.Errorf("feature moved to %v, want it left at %s", shas, r.A)
 	}
 }
 
@@ -412,14 +982,16 @@ func TestSync_NoRemotes(t *testing.T) {
 	os.Chdir(dir)
 	t.Cleanup(func() { os.Chdir(orig) })
 
-	git.Verbose = false
-	git.Color = false
+	closeLogging()
 
 	var stdout, stderr bytes.Buffer
-	err := sync(&stdout, &stderr, false)
+	res, err := sync(t.Context(), &stdout, &stderr, options{})
 	if err == nil {
 		t.Fatal("expected error when no remotes exist")
 	}
+	if code := exitCode(res, err); code != exitNoRepo {
+		t.Errorf("exitCode() = %d, want %d", code, exitNoRepo)
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// What sync does with a branch once it has been classified.
+const (
+	actionNone   = "none"   // nothing to do
+	actionUpdate = "update" // fast-forward to the remote branch
+	actionDelete = "delete" // merged and gone from the remote
+	actionKeep   = "keep"   // would be changed, but the settings say not to
+	actionWarn   = "warn"   // needs the user's attention; left alone
+)
+
+// planContext is what sync knows about the repository when it decides what
+// to do with each branch.
+type planContext struct {
+	remote        string
+	defaultBranch string
+	currentBranch string
+	bareHead      string            // branch named by the HEAD of a bare repository
+	worktreeOf    map[string]string // branches checked out in other worktrees, and where
+	opts          options
+}
+
+// checkedOut fills in which branches are checked out in other worktrees or
+// named by the HEAD of a bare repository. Those can only be fast-forwarded
+// from inside their worktree, and must never be deleted.
+func (pc *planContext) checkedOut(ctx context.Context, repo *git.RepoInfo) error {
+	worktrees, err := git.Worktrees(ctx)
+	if err != nil {
+		return err
+	}
+
+	pc.worktreeOf = make(map[string]string)
+	for _, wt := range worktrees {
+		switch {
+		case wt.Bare:
+			// A bare repository's HEAD names a branch without checking it out.
+			// Moving it is fine, but deleting it would leave HEAD dangling.
+			pc.bareHead, _ = git.HeadBranch(ctx, repo.CommonDir)
+		case wt.Branch != "" && wt.Branch != pc.currentBranch:
+			pc.worktreeOf[wt.Branch] = wt.Path
+		}
+	}
+	return nil
+}
+
+// plan is what sync will do with a branch, and why.
+type plan struct {
+	action   string
+	reason   string // why, for anything but a plain update, delete or none
+	warning  string // what to tell the user when action is actionWarn
+	worktree string // for an update, the other worktree the branch is checked out in
+}
+
+// planBranch decides what sync does with a branch in the state st.
+// Protected branches are never changed, neither fast-forwarded nor deleted.
+func planBranch(ctx context.Context, branch string, st branchStatus, pc *planContext) plan {
+	switch st.state {
+	case stateBehind:
+		if matchesAny(branch, pc.opts.protect) {
+			trace(ctx, "%s matches a protected pattern, so it is not fast-forwarded", branch)
+			return plan{action: actionKeep, reason: "protected"}
+		}
+		worktree := pc.worktreeOf[branch]
+		if worktree != "" {
+			trace(ctx, "%s is checked out in %s, so it is fast-forwarded there", branch, worktree)
+			if !git.IsWorktreeClean(ctx, worktree) {
+				return plan{
+					action:  actionWarn,
+					reason:  "checked out in a worktree with uncommitted changes",
+					warning: fmt.Sprintf("'%s' is checked out in %s, which has uncommitted changes; not updated", branch, worktree),
+				}
+			}
+		}
+		return plan{action: actionUpdate, worktree: worktree}
+
+	case stateAhead, stateDiverged:
+		return plan{
+			action:  actionWarn,
+			reason:  "unpushed commits",
+			warning: fmt.Sprintf("'%s' seems to contain unpushed commits", branch),
+		}
+
+	case stateGoneUnmerged:
+		return plan{
+			action:  actionWarn,
+			reason:  "not merged into " + pc.defaultBranch,
+			warning: fmt.Sprintf("'%s' was deleted on %s, but appears not merged into '%s'", branch, pc.remote, pc.defaultBranch),
+		}
+
+	case stateGoneMerged:
+		// The upstream branch was deleted from the remote after landing.
+		var keep string
+		switch {
+		case pc.opts.deletePolicy == deleteNever:
+			return plan{action: actionKeep, reason: "delete policy is never"}
+		case matchesAny(branch, pc.opts.protect):
+			return plan{action: actionKeep, reason: "protected"}
+		case pc.worktreeOf[branch] != "":
+			keep = fmt.Sprintf("is checked out in %s", pc.worktreeOf[branch])
+		case branch == pc.bareHead:
+			keep = "is the HEAD of the bare repository"
+		case branch == pc.currentBranch && pc.worktreeOf[pc.defaultBranch] != "":
+			keep = fmt.Sprintf("'%s' is checked out in %s so there is nothing to switch to",
+				pc.defaultBranch, pc.worktreeOf[pc.defaultBranch])
+		}
+		if keep != "" {
+			return plan{
+				action:  actionWarn,
+				reason:  keep,
+				warning: fmt.Sprintf("'%s' was deleted on %s and appears merged, but %s; kept", branch, pc.remote, keep),
+			}
+		}
+		return plan{action: actionDelete}
+	}
+
+	return plan{action: actionNone}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// staleReport describes a stale branch in JSON output.
+type staleReport struct {
+	Branch     string    `json:"branch"`
+	LastCommit time.Time `json:"lastCommit"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Track      string    `json:"track,omitempty"`
+	Deleted    bool      `json:"deleted"`
+}
+
+// parseAge parses a threshold such as "90d" or "12w", falling back to Go
+// duration syntax for anything finer, like "36h".
+func parseAge(s string) (time.Duration, error) {
+	unit := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if s != "" {
+		if mult, ok := unit[s[len(s)-1]]; ok {
+			n, err := strconv.Atoi(s[:len(s)-1])
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(n) * mult, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (use e.g. 90d, 12w or 36h)", s)
+	}
+	return d, nil
+}
+
+// stale lists local branches whose last commit is older than olderThan. With
+// del, those that are merged or squash-merged into the default branch are
+// deleted, using the same checks and safeguards as sync.
+func stale(ctx context.Context, stdout, stderr io.Writer, opts options, olderThan time.Duration, del bool) (result, error) {
+	var res result
+
+	if _, err := git.Inspect(ctx); err != nil {
+		return res, err
+	}
+
+	infos, err := git.BranchInfos(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
+	selected := selectBranches(names, opts.include, opts.exclude)
+
+	now := time.Now()
+	var staleBranches []git.BranchInfo
+	for _, info := range infos {
+		if slices.Contains(selected, info.Name) && now.Sub(info.CommitDate) > olderThan {
+			staleBranches = append(staleBranches, info)
+		}
+	}
+
+	if opts.format == formatJSON {
+		var deleted map[string]bool
+		if del && len(staleBranches) > 0 {
+			if deleted, err = deleteStale(ctx, stdout, stderr, opts, staleBranches, &res); err != nil {
+				return res, err
+			}
+		}
+
+		reports := make([]staleReport, 0, len(staleBranches))
+		for _, info := range staleBranches {
+			reports = append(reports, staleReport{
+				Branch:     info.Name,
+				LastCommit: info.CommitDate,
+				Upstream:   info.Upstream,
+				Track:      info.Track,
+				Deleted:    deleted[info.Name],
+			})
+		}
+		return res, json.NewEncoder(stdout).Encode(reports)
+	}
+
+	if len(staleBranches) == 0 {
+		return res, nil
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BRANCH\tLAST COMMIT\tUPSTREAM")
+	for _, info := range staleBranches {
+		upstream := strings.TrimSpace(info.Upstream + " " + info.Track)
+		if upstream == "" {
+			upstream = "(none)"
+		}
+		days := int(now.Sub(info.CommitDate).Hours() / 24)
+		fmt.Fprintf(tw, "%s\t%d days ago\t%s\n", info.Name, days, upstream)
+	}
+	if err := tw.Flush(); err != nil {
+		return res, err
+	}
+
+	if del {
+		_, err = deleteStale(ctx, stdout, stderr, opts, staleBranches, &res)
+	}
+	return res, err
+}
+
+// deleteStale deletes the stale branches that are safe to delete in a single
+// transaction and returns their names. The default branch, protected
+// branches, checked-out branches and anything not merged into the default
+// branch are kept.
+func deleteStale(ctx context.Context, stdout, stderr io.Writer, opts options, branches []git.BranchInfo, res *result) (map[string]bool, error) {
+	errOut := newTheme(opts.color, stderr)
+	warn := func(branch, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		errOut.warnf(stderr, "%s", msg)
+		res.warnings++
+		res.branches = append(res.branches, branchReport{Branch: branch, Action: "warning", Message: msg})
+	}
+
+	out := newTheme(opts.color, stdout)
+
+	repo, err := git.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := mainRemote(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defaultBranch := resolveDefaultBranch(ctx, stderr, opts, remote, false)
+	defaultRef := fmt.Sprintf("refs/remotes/%s/%s", remote, defaultBranch)
+
+	// Nothing checked out anywhere is deleted, including the branch named by
+	// a bare repository's HEAD.
+	worktrees, err := git.Worktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkedOut := make(map[string]string)
+	for _, wt := range worktrees {
+		if wt.Bare {
+			if head, err := git.HeadBranch(ctx, repo.CommonDir); err == nil {
+				checkedOut[head] = wt.Path
+			}
+		} else if wt.Branch != "" {
+			checkedOut[wt.Branch] = wt.Path
+		}
+	}
+
+	var changes []refChange
+	for _, info := range branches {
+		branch := info.Name
+		localRef := "refs/heads/" + branch
+
+		switch {
+		case branch == defaultBranch:
+			continue
+		case opts.deletePolicy == deleteNever:
+			res.branches = append(res.branches, branchReport{Branch: branch, Action: "kept", Message: "delete policy is never"})
+			continue
+		case matchesAny(branch, opts.protect):
+			res.branches = append(res.branches, branchReport{Branch: branch, Action: "kept", Message: "protected"})
+			continue
+		case checkedOut[branch] != "":
+			warn(branch, "'%s' is stale but is checked out in %s; kept", branch, checkedOut[branch])
+			continue
+		}
+
+		r, err := git.NewRange(ctx, localRef, defaultRef)
+		if err != nil {
+			return nil, err
+		}
+		if !isMerged(ctx, r, localRef, defaultRef, opts.deletePolicy) {
+			warn(branch, "'%s' is stale but appears not merged into '%s'; kept", branch, defaultBranch)
+			continue
+		}
+		upstreamRemote, merge := git.BranchUpstream(ctx, branch)
+		changes = append(changes, refChange{branch: branch, oldValue: r.A, remote: upstreamRemote, merge: merge})
+	}
+
+	tx := git.NewTransaction("gh-sync: delete stale branches")
+	for _, c := range changes {
+		tx.Delete("refs/heads/"+c.branch, c.oldValue)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("no branches were deleted: %w", err)
+	}
+
+	// The branches are gone now; their config goes too, interrupted or not.
+	deleted := make(map[string]bool)
+	for _, c := range changes {
+		git.RemoveBranchConfig(context.WithoutCancel(ctx), c.branch)
+		deleted[c.branch] = true
+		res.branches = append(res.branches, branchReport{Branch: c.branch, Action: "deleted", From: c.oldValue})
+		if opts.format != formatJSON {
+			fmt.Fprintf(stdout, "%s%s (was %s).\n",
+				out.deleted("Deleted branch "), out.deletedBranch(c.branch), c.oldValue[:7])
+		}
+	}
+
+	if err := writeJournal(repo.CommonDir, changes); err != nil {
+		errOut.warnf(stderr, "could not record changes for undo: %s", err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90d", 90 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"0d", 0, false},
+		{"d", 0, true},
+		{"-3d", 0, true},
+		{"ninety", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAge(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAge(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAge(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// oldBranch creates a local branch off main with one commit dated age ago.
+func (e *testEnv) oldBranch(name string, age time.Duration) {
+	e.t.Helper()
+	mustExec(e.t, e.local, "git", "checkout", "-b", name, "main")
+	writeTestFile(e.t, filepath.Join(e.local, name+".txt"), name+"\n")
+	mustExec(e.t, e.local, "git", "add", ".")
+
+	date := time.Now().Add(-age).Format(time.RFC3339)
+	cmd := exec.Command("git", "commit", "-m", "old work on "+name)
+	cmd.Dir = e.local
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		e.t.Fatalf("commit on %s failed: %v\n%s", name, err, out)
+	}
+	mustExec(e.t, e.local, "git", "checkout", "main")
+}
+
+func TestStale_List(t *testing.T) {
+	env := newTestEnv(t)
+	env.oldBranch("ancient", 200*24*time.Hour)
+	env.oldBranch("recent", 5*24*time.Hour)
+	env.chdir()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stale(t.Context(), &stdout, &stderr, options{}, 90*24*time.Hour, false); err != nil {
+		t.Fatalf("stale error: %v\nstderr: %s", err, stderr.String())
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "ancient") || !strings.Contains(out, "200 days ago") {
+		t.Errorf("expected ancient to be listed as 200 days old, got:\n%s", out)
+	}
+	if strings.Contains(out, "recent") {
+		t.Errorf("recent branch should not be listed, got:\n%s", out)
+	}
+	if !git.HasRef(t.Context(), "refs/heads/ancient") {
+		t.Error("listing must not delete anything")
+	}
+}
+
+func TestStale_DeleteOnlyMerged(t *testing.T) {
+	env := newTestEnv(t)
+	env.oldBranch("old-merged", 200*24*time.Hour)
+	env.oldBranch("old-unmerged", 200*24*time.Hour)
+	env.oldBranch("release/1.0", 200*24*time.Hour)
+
+	// Land old-merged and release/1.0 on the remote's main.
+	mustExec(t, env.local, "git", "push", "origin", "old-merged", "release/1.0")
+	env.mergeOnRemote("old-merged")
+	env.mergeOnRemote("release/1.0")
+	mustExec(t, env.local, "git", "fetch", "--quiet", "origin")
+	env.chdir()
+
+	var stdout, stderr bytes.Buffer
+	opts := options{protect: []string{"release/*"}, format: formatJSON}
+	res, err := stale(t.Context(), &stdout, &stderr, opts, 90*24*time.Hour, true)
+	if err != nil {
+		t.Fatalf("stale error: %v\nstderr: %s", err, stderr.String())
+	}
+
+	var reports []staleReport
+	if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout.String())
+	}
+	deleted := map[string]bool{}
+	for _, r := range reports {
+		deleted[r.Branch] = r.Deleted
+	}
+	want := map[string]bool{"old-merged": true, "old-unmerged": false, "release/1.0": false}
+	for branch, wantDeleted := range want {
+		if deleted[branch] != wantDeleted {
+			t.Errorf("%s deleted = %v, want %v", branch, deleted[branch], wantDeleted)
+		}
+		if git.HasRef(t.Context(), "refs/heads/"+branch) == wantDeleted {
+			t.Errorf("%s exists = %v, want %v", branch, !wantDeleted, !wantDeleted)
+		}
+	}
+
+	if !strings.Contains(stderr.String(), "'old-unmerged' is stale but appears not merged") {
+		t.Errorf("expected unmerged warning, got stderr: %s", stderr.String())
+	}
+	if res.warnings != 1 {
+		t.Errorf("warnings = %d, want 1", res.warnings)
+	}
+}
+
+func TestStale_KeepsDefaultBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.chdir()
+
+	// Every commit in the fixture is fresh, so use a zero threshold to make
+	// main itself count as stale.
+	var stdout, stderr bytes.Buffer
+	if _, err := stale(t.Context(), &stdout, &stderr, options{}, 0, true); err != nil {
+		t.Fatalf("stale error: %v\nstderr: %s", err, stderr.String())
+	}
+	if !git.HasRef(t.Context(), "refs/heads/main") {
+		t.Error("the default branch must never be deleted")
+	}
+}
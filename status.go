@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// branchState is how a local branch relates to its counterpart on the remote.
+type branchState int
+
+const (
+	stateUpToDate     branchState = iota // same commit as the remote branch
+	stateBehind                          // can be fast-forwarded
+	stateAhead                           // has unpushed commits
+	stateDiverged                        // has unpushed commits and is behind
+	stateGoneMerged                      // upstream deleted, work landed in the default branch
+	stateGoneUnmerged                    // upstream deleted, work not found in the default branch
+	stateUntracked                       // no counterpart on the remote
+)
+
+var stateNames = [...]string{
+	stateUpToDate:     "up to date",
+	stateBehind:       "behind",
+	stateAhead:        "ahead",
+	stateDiverged:     "diverged",
+	stateGoneMerged:   "gone, merged",
+	stateGoneUnmerged: "gone, not merged",
+	stateUntracked:    "untracked",
+}
+
+func (s branchState) String() string {
+	return stateNames[s]
+}
+
+// needsAttention reports whether the branch holds work that sync won't
+// update or clean up on its own.
+func (s branchState) needsAttention() bool {
+	return s == stateAhead || s == stateDiverged || s == stateGoneUnmerged
+}
+
+// branchStatus is the classification of a single local branch.
+type branchStatus struct {
+	state     branchState
+	remoteRef string     // the remote branch compared against; empty when gone or untracked
+	r         *git.Range // local against remoteRef, or against the default branch when gone
+}
+
+// classifyBranch compares a local branch with its counterpart on remote.
+//
+// A branch whose branch.<name>.remote is remote is compared with its
+// configured upstream, and counts as gone when that no longer exists. Any
+// other branch is compared with the remote branch of the same name, if
+// there is one.
+func classifyBranch(ctx context.Context, branch, remote, defaultRef string, branchRemotes map[string]string, policy string) (branchStatus, error) {
+	localRef := fmt.Sprintf("refs/heads/%s", branch)
+	remoteRef := fmt.Sprintf("refs/remotes/%s/%s", remote, branch)
+
+	if branchRemotes[branch] == remote {
+		// Branch is configured to track this remote.
+		// Try to resolve its upstream; if that fails the upstream was deleted.
+		trace(ctx, "branch.%s.remote is %s, so it is compared with its upstream", branch, remote)
+		upstream, err := git.UpstreamRef(ctx, branch)
+		if err != nil {
+			trace(ctx, "its upstream no longer exists, so it is gone; checking whether it landed in %s", defaultRef)
+			r, err := git.NewRange(ctx, localRef, defaultRef)
+			if err != nil {
+				return branchStatus{}, err
+			}
+			trace(ctx, "%s is at %s, %s at %s", localRef, r.A[:7], defaultRef, r.B[:7])
+			if isMerged(ctx, r, localRef, defaultRef, policy) {
+				return branchStatus{state: stateGoneMerged, r: r}, nil
+			}
+			return branchStatus{state: stateGoneUnmerged, r: r}, nil
+		}
+		trace(ctx, "its upstream is %s", upstream)
+		remoteRef = upstream
+	} else {
+		if configured := branchRemotes[branch]; configured != "" {
+			trace(ctx, "branch.%s.remote is %s, not %s, so it is compared with %s by name", branch, configured, remote, remoteRef)
+		} else {
+			trace(ctx, "branch.%s.remote is not set, so it is compared with %s by name", branch, remoteRef)
+		}
+		if !git.HasRef(ctx, remoteRef) {
+			// No tracking config and no matching branch on the remote.
+			trace(ctx, "%s does not exist", remoteRef)
+			return branchStatus{state: stateUntracked}, nil
+		}
+	}
+
+	r, err := git.NewRange(ctx, localRef, remoteRef)
+	if err != nil {
+		return branchStatus{}, err
+	}
+	trace(ctx, "%s is at %s, %s at %s", localRef, r.A[:7], remoteRef, r.B[:7])
+
+	st := branchStatus{remoteRef: remoteRef, r: r}
+	switch {
+	case r.IsIdentical():
+		st.state = stateUpToDate
+	case r.IsAncestor(ctx):
+		trace(ctx, "%s is an ancestor of %s", localRef, remoteRef)
+		st.state = stateBehind
+	case r.IsDescendant(ctx):
+		trace(ctx, "%s is an ancestor of %s", remoteRef, localRef)
+		st.state = stateAhead
+	default:
+		trace(ctx, "neither is an ancestor of the other")
+		st.state = stateDiverged
+	}
+	return st, nil
+}
+
+// statusReport describes a branch in status JSON output.
+type statusReport struct {
+	Branch   string `json:"branch"`
+	Status   string `json:"status"`
+	Upstream string `json:"upstream,omitempty"`
+	Current  bool   `json:"current,omitempty"`
+}
+
+// status prints how each local branch compares with the main remote without
+// changing anything. Nothing is fetched unless fetch is set. Branches with
+// unpushed or unmerged work count as warnings, so the exit code tells a
+// script whether a repository needs attention.
+func status(ctx context.Context, stdout, stderr io.Writer, opts options, fetch bool) (result, error) {
+	var res result
+
+	repo, err := git.Inspect(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	remote, err := mainRemote(ctx, opts)
+	if err != nil {
+		return res, err
+	}
+	res.remote = remote
+
+	currentBranch, _ := git.CurrentBranch(ctx)
+	if !repo.WorkTree {
+		currentBranch = ""
+	}
+
+	if fetch {
+		if err := git.Fetch(ctx, remote, opts.fetchOptions()); err != nil {
+			return res, &exitError{code: exitFetchFailed, err: fmt.Errorf("fetch failed: %w", err)}
+		}
+	}
+
+	defaultBranch := resolveDefaultBranch(ctx, stderr, opts, remote, fetch)
+	defaultRef := fmt.Sprintf("refs/remotes/%s/%s", remote, defaultBranch)
+	res.defaultBranch = defaultBranch
+
+	branchRemotes := git.BranchRemotes(ctx)
+
+	branches, err := git.LocalBranches(ctx)
+	if err != nil {
+		return res, err
+	}
+	branches = selectBranches(branches, opts.include, opts.exclude)
+
+	reports := make([]statusReport, 0, len(branches))
+	for _, branch := range branches {
+		st, err := classifyBranch(ctx, branch, remote, defaultRef, branchRemotes, opts.deletePolicy)
+		if err != nil {
+			return res, err
+		}
+		if st.state.needsAttention() {
+			res.warnings++
+		}
+		reports = append(reports, statusReport{
+			Branch:   branch,
+			Status:   st.state.String(),
+			Upstream: strings.TrimPrefix(st.remoteRef, "refs/remotes/"),
+			Current:  branch == currentBranch,
+		})
+	}
+
+	if opts.format == formatJSON {
+		return res, json.NewEncoder(stdout).Encode(reports)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  BRANCH\tSTATUS\tUPSTREAM")
+	for _, r := range reports {
+		marker := " "
+		if r.Current {
+			marker = "*"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\n", marker, r.Branch, r.Status, r.Upstream)
+	}
+	return res, tw.Flush()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+func TestStatus(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.createBranch("in-sync", "in-sync.txt", "in sync\n")
+
+	env.createBranch("behind", "behind.txt", "behind\n")
+	env.addRemoteCommit("behind", "behind-remote.txt", "remote\n")
+
+	env.createBranch("ahead", "ahead.txt", "ahead\n")
+	mustExec(t, env.local, "git", "checkout", "ahead")
+	writeTestFile(t, filepath.Join(env.local, "ahead-local.txt"), "local\n")
+	mustExec(t, env.local, "git", "add", ".")
+	mustExec(t, env.local, "git", "commit", "-m", "local work")
+	mustExec(t, env.local, "git", "checkout", "main")
+
+	env.createBranch("diverged", "diverged.txt", "diverged\n")
+	env.addRemoteCommit("diverged", "diverged-remote.txt", "remote\n")
+	mustExec(t, env.local, "git", "checkout", "diverged")
+	writeTestFile(t, filepath.Join(env.local, "diverged-local.txt"), "local\n")
+	mustExec(t, env.local, "git", "add", ".")
+	mustExec(t, env.local, "git", "commit", "-m", "local work")
+	mustExec(t, env.local, "git", "checkout", "main")
+
+	env.createBranch("landed", "landed.txt", "landed\n")
+	env.mergeOnRemote("landed")
+	env.deleteRemoteBranch("landed")
+
+	env.createBranch("abandoned", "abandoned.txt", "abandoned\n")
+	env.deleteRemoteBranch("abandoned")
+
+	mustExec(t, env.local, "git", "branch", "local-only")
+
+	env.chdir()
+
+	var stdout bytes.Buffer
+	res, err := status(t.Context(), &stdout, io.Discard, options{format: formatJSON}, true)
+	if err != nil {
+		t.Fatalf("status error: %v", err)
+	}
+
+	var reports []statusReport
+	if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout.String())
+	}
+	got := make(map[string]string)
+	for _, r := range reports {
+		got[r.Branch] = r.Status
+	}
+
+	want := map[string]string{
+		"main":       "behind",
+		"in-sync":    "up to date",
+		"behind":     "behind",
+		"ahead":      "ahead",
+		"diverged":   "diverged",
+		"landed":     "gone, merged",
+		"abandoned":  "gone, not merged",
+		"local-only": "untracked",
+	}
+	for branch, state := range want {
+		if got[branch] != state {
+			t.Errorf("%s status = %q, want %q", branch, got[branch], state)
+		}
+	}
+
+	// ahead, diverged and abandoned all hold work sync won't touch
+	if code := exitCode(res, err); code != exitWarnings || res.warnings != 3 {
+		t.Errorf("exitCode() = %d with %d warnings, want %d with 3", code, res.warnings, exitWarnings)
+	}
+}
+
+func TestStatus_ChangesNothing(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "feature\n")
+	env.addRemoteCommit("feature", "remote.txt", "remote\n")
+	// Without a HEAD ref, the default branch is guessed rather than asked for.
+	mustExec(t, env.local, "git", "remote", "set-head", "origin", "--delete")
+	env.chdir()
+
+	before, err := git.RevParse(t.Context(), "refs/heads/feature", "refs/remotes/origin/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := status(t.Context(), &stdout, &stderr, options{}, false); err != nil {
+		t.Fatalf("status error: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "could not confirm the default branch of origin; assuming 'main'") {
+		t.Errorf("expected a warning about the guessed default branch, got stderr: %s", stderr.String())
+	}
+
+	// Without --fetch the new remote commit isn't seen yet
+	out := stdout.String()
+	if !strings.Contains(out, "feature") || !strings.Contains(out, "up to date") {
+		t.Errorf("expected feature to be up to date with the stale remote ref, got:\n%s", out)
+	}
+	if !strings.Contains(out, "* main") {
+		t.Errorf("expected the current branch to be marked, got:\n%s", out)
+	}
+
+	after, err := git.RevParse(t.Context(), "refs/heads/feature", "refs/remotes/origin/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before[0] != after[0] || before[1] != after[1] {
+		t.Errorf("status moved refs: before %v, after %v", before, after)
+	}
+	if git.HasRef(t.Context(), "refs/remotes/origin/HEAD") {
+		t.Error("status recorded refs/remotes/origin/HEAD")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Color modes.
+const (
+	colorAuto   = "auto"   // color terminals, unless the environment says otherwise
+	colorAlways = "always" // color even when writing to a file or pipe
+	colorNever  = "never"  // never color
+)
+
+// theme styles what gh sync prints. The zero theme prints plain text.
+type theme struct {
+	color bool
+}
+
+// newTheme returns the theme for output written to w in the given color
+// mode. In auto mode NO_COLOR turns color off, and CLICOLOR_FORCE turns it
+// on for writers that aren't terminals, such as CI logs, as does gh's
+// GH_FORCE_TTY; CLICOLOR=0 turns it off for terminals. See
+// https://no-color.org and https://bixense.com/clicolors.
+func newTheme(mode string, w io.Writer) theme {
+	switch mode {
+	case colorAlways:
+		return theme{color: true}
+	case colorNever:
+		return theme{}
+	}
+
+	switch {
+	case os.Getenv("NO_COLOR") != "":
+		return theme{}
+	case os.Getenv("CLICOLOR_FORCE") != "" && os.Getenv("CLICOLOR_FORCE") != "0",
+		os.Getenv("GH_FORCE_TTY") != "":
+		return theme{color: true}
+	case os.Getenv("CLICOLOR") == "0":
+		return theme{}
+	}
+	return theme{color: isTerminal(w)}
+}
+
+// isTerminal reports whether w is a terminal that can display colors.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+func (t theme) paint(sgr, s string) string {
+	if !t.color {
+		return s
+	}
+	return "\033[" + sgr + "m" + s + "\033[0m"
+}
+
+func (t theme) updated(s string) string       { return t.paint("32", s) }
+func (t theme) updatedBranch(s string) string { return t.paint("1;32", s) }
+func (t theme) deleted(s string) string       { return t.paint("31", s) }
+func (t theme) deletedBranch(s string) string { return t.paint("1;31", s) }
+func (t theme) command(s string) string       { return t.paint("35", s) }
+func (t theme) failure(s string) string       { return t.paint("31", s) }
+
+// warnf writes a warning line to w.
+func (t theme) warnf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, "%s %s\n", t.paint("1;33", "warning:"), fmt.Sprintf(format, args...))
+}
+
+// errorf writes an error line to w.
+func (t theme) errorf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, "%s %s\n", t.paint("1;31", "error:"), fmt.Sprintf(format, args...))
+}
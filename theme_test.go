@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewTheme(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		env  map[string]string
+		want bool
+	}{
+		{"auto, not a terminal", colorAuto, nil, false},
+		{"always", colorAlways, nil, true},
+		{"always beats NO_COLOR", colorAlways, map[string]string{"NO_COLOR": "1"}, true},
+		{"never beats CLICOLOR_FORCE", colorNever, map[string]string{"CLICOLOR_FORCE": "1"}, false},
+		{"CLICOLOR_FORCE", colorAuto, map[string]string{"CLICOLOR_FORCE": "1"}, true},
+		{"CLICOLOR_FORCE=0", colorAuto, map[string]string{"CLICOLOR_FORCE": "0"}, false},
+		{"NO_COLOR beats CLICOLOR_FORCE", colorAuto, map[string]string{"NO_COLOR": "1", "CLICOLOR_FORCE": "1"}, false},
+		{"GH_FORCE_TTY", colorAuto, map[string]string{"GH_FORCE_TTY": "1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"NO_COLOR", "CLICOLOR_FORCE", "CLICOLOR", "GH_FORCE_TTY"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := newTheme(tt.mode, &bytes.Buffer{}).color; got != tt.want {
+				t.Errorf("newTheme(%q).color = %t, want %t", tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTheme_Warnf(t *testing.T) {
+	var buf bytes.Buffer
+	theme{}.warnf(&buf, "'%s' seems to contain unpushed commits", "feature")
+	if got, want := buf.String(), "warning: 'feature' seems to contain unpushed commits\n"; got != want {
+		t.Errorf("plain warnf() = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	theme{color: true}.warnf(&buf, "careful")
+	if got, want := buf.String(), "\033[1;33mwarning:\033[0m careful\n"; got != want {
+		t.Errorf("colored warnf() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// timings is the --timings collector, set from setupLogging to closeLogging.
+var timings *timingsHandler
+
+// timing adds up the runs of one git subcommand.
+type timing struct {
+	subcommand string
+	calls      int
+	total      time.Duration
+	max        time.Duration
+}
+
+// timingsHandler adds up how long each git subcommand took from the records
+// git.Logger writes for finished commands, grouping them by their subcommand
+// attribute. It ignores every other record.
+type timingsHandler struct {
+	bySubcommand map[string]*timing
+}
+
+func newTimingsHandler() *timingsHandler {
+	return &timingsHandler{bySubcommand: make(map[string]*timing)}
+}
+
+func (h *timingsHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level == slog.LevelDebug
+}
+
+func (h *timingsHandler) Handle(_ context.Context, r slog.Record) error {
+	if r.Message != "git" {
+		return nil
+	}
+
+	var subcommand string
+	var duration time.Duration
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "subcommand":
+			subcommand = a.Value.String()
+		case "duration":
+			duration = a.Value.Duration()
+		}
+		return true
+	})
+
+	t := h.bySubcommand[subcommand]
+	if t == nil {
+		t = &timing{subcommand: subcommand}
+		h.bySubcommand[subcommand] = t
+	}
+	t.calls++
+	t.total += duration
+	t.max = max(t.max, duration)
+	return nil
+}
+
+func (h *timingsHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *timingsHandler) WithGroup(string) slog.Handler      { return h }
+
+// print writes a table of git subcommands, the slowest in total first.
+func (h *timingsHandler) print(w io.Writer) {
+	var rows []*timing
+	var sum timing
+	for _, t := range h.bySubcommand {
+		rows = append(rows, t)
+		sum.calls += t.calls
+		sum.total += t.total
+		sum.max = max(sum.max, t.max)
+	}
+	slices.SortFunc(rows, func(a, b *timing) int {
+		return cmp.Or(cmp.Compare(b.total, a.total), cmp.Compare(a.subcommand, b.subcommand))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GIT COMMAND\tCALLS\tTOTAL\tMAX")
+	for _, t := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", t.subcommand, t.calls, roundDuration(t.total), roundDuration(t.max))
+	}
+	fmt.Fprintf(tw, "all\t%d\t%s\t%s\n", sum.calls, roundDuration(sum.total), roundDuration(sum.max))
+	tw.Flush()
+}
+
+// roundDuration drops precision nobody needs when comparing runs.
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingsHandler(t *testing.T) {
+	h := newTimingsHandler()
+	log := slog.New(h)
+	for _, c := range []struct {
+		args       string
+		subcommand string
+		duration   time.Duration
+	}{
+		{"rev-parse --quiet HEAD", "rev-parse", 2 * time.Millisecond},
+		{"fetch --prune --quiet --progress origin", "fetch", 1500 * time.Millisecond},
+		{"rev-parse --quiet main", "rev-parse", 5 * time.Millisecond},
+		{"-C /src/wt status --porcelain --untracked-files=no", "status", 3 * time.Millisecond},
+	} {
+		log.Debug("git", "args", c.args, "subcommand", c.subcommand, "duration", c.duration, "exit", 0)
+	}
+	log.Info("decision", "branch", "main", "action", "none")
+
+	var buf bytes.Buffer
+	h.print(&buf)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := [][]string{
+		{"GIT", "COMMAND", "CALLS", "TOTAL", "MAX"},
+		{"fetch", "1", "1.5s", "1.5s"},
+		{"rev-parse", "2", "7ms", "5ms"},
+		{"status", "1", "3ms", "3ms"},
+		{"all", "4", "1.51s", "1.5s"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, line := range lines {
+		if got := strings.Fields(line); strings.Join(got, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d = %q, want fields %v", i, line, want[i])
+		}
+	}
+}
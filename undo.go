@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+// journalPath is where the changes made by the last run are recorded. It
+// lives in the common git dir so every worktree shares one journal.
+func journalPath(commonDir string) string {
+	return filepath.Join(commonDir, "gh-sync", "last-run")
+}
+
+// writeJournal records changes, one "<old> <new> <branch>" line each, with
+// "-" as the new value of a deleted branch. A deleted branch that tracked a
+// remote branch has its "<remote> <merge>" config appended. A run that
+// changed nothing removes the journal, so undo never reaches past it.
+func writeJournal(commonDir string, changes []refChange) error {
+	path := journalPath(commonDir)
+	if len(changes) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, c := range changes {
+		newValue := c.newValue
+		if c.isDelete() {
+			newValue = "-"
+		}
+		fmt.Fprintf(&b, "%s %s %s", c.oldValue, newValue, c.branch)
+		if c.isDelete() && c.remote != "" && c.merge != "" {
+			fmt.Fprintf(&b, " %s %s", c.remote, c.merge)
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// readJournal loads the changes recorded by the last run.
+func readJournal(commonDir string) ([]refChange, error) {
+	f, err := os.Open(journalPath(commonDir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var changes []refChange
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 && len(fields) != 5 {
+			return nil, fmt.Errorf("corrupt undo journal line: %q", scanner.Text())
+		}
+		c := refChange{oldValue: fields[0], newValue: fields[1], branch: fields[2]}
+		if len(fields) == 5 {
+			c.remote, c.merge = fields[3], fields[4]
+		}
+		if c.newValue == "-" {
+			c.newValue = ""
+		}
+		changes = append(changes, c)
+	}
+	return changes, scanner.Err()
+}
+
+// undo restores the branches changed by the last run in one transaction:
+// fast-forwarded branches move back and deleted branches are recreated,
+// tracking the remote branch they tracked before.
+// Branches that are checked out somewhere are left alone, since moving them
+// would leave a working tree out of step with its branch.
+func undo(ctx context.Context, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
+
+	repo, err := git.Inspect(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	changes, err := readJournal(repo.CommonDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, errors.New("nothing to undo")
+	}
+	if err != nil {
+		return res, err
+	}
+
+	worktrees, err := git.Worktrees(ctx)
+	if err != nil {
+		return res, err
+	}
+	checkedOut := make(map[string]string)
+	for _, wt := range worktrees {
+		if wt.Branch != "" {
+			checkedOut[wt.Branch] = wt.Path
+		}
+	}
+
+	tx := git.NewTransaction("gh-sync: undo")
+	var restored []refChange
+	for _, c := range changes {
+		ref := "refs/heads/" + c.branch
+		switch {
+		case c.isDelete():
+			tx.Create(ref, c.oldValue)
+		case checkedOut[c.branch] != "":
+			newTheme(opts.color, stderr).warnf(stderr, "'%s' is checked out in %s; run 'git reset --keep %s' there to undo",
+				c.branch, checkedOut[c.branch], c.oldValue[:7])
+			res.warnings++
+			continue
+		default:
+			tx.Update(ref, c.oldValue, c.newValue)
+		}
+		restored = append(restored, c)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("nothing was undone: %w", err)
+	}
+
+	for _, c := range restored {
+		if c.isDelete() && c.remote != "" {
+			if err := git.SetBranchUpstream(ctx, c.branch, c.remote, c.merge); err != nil {
+				newTheme(opts.color, stderr).warnf(stderr, "could not restore the upstream of '%s': %s", c.branch, err)
+				res.warnings++
+			}
+		}
+		if c.isDelete() {
+			fmt.Fprintf(stdout, "Restored branch %s (at %s).\n", c.branch, c.oldValue[:7])
+		} else {
+			fmt.Fprintf(stdout, "Reset branch %s to %s (was %s).\n", c.branch, c.oldValue[:7], c.newValue[:7])
+		}
+	}
+
+	return res, os.Remove(journalPath(repo.CommonDir))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wassimk/gh-sync/internal/git"
+)
+
+func TestUndo(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.createBranch("merged-feature", "merged.txt", "content\n")
+	env.mergeOnRemote("merged-feature")
+	env.deleteRemoteBranch("merged-feature")
+	env.chdir()
+
+	featureBefore := mustExec(t, env.local, "git", "rev-parse", "feature")
+	mergedBefore := mustExec(t, env.local, "git", "rev-parse", "merged-feature")
+
+	if _, stderr, err := runSync(t); err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if git.HasRef(t.Context(), "refs/heads/merged-feature") {
+		t.Fatal("merged-feature should have been deleted by sync")
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := undo(t.Context(), &stdout, &stderr, options{}); err != nil {
+		t.Fatalf("undo error: %v\nstderr: %s", err, stderr.String())
+	}
+
+	if got := mustExec(t, env.local, "git", "rev-parse", "feature"); got != featureBefore {
+		t.Errorf("feature = %s, want %s", got, featureBefore)
+	}
+	if got := mustExec(t, env.local, "git", "rev-parse", "merged-feature"); got != mergedBefore {
+		t.Errorf("merged-feature = %s, want %s", got, mergedBefore)
+	}
+	if !strings.Contains(stdout.String(), "Restored branch merged-feature") {
+		t.Errorf("expected restore message, got stdout: %s", stdout.String())
+	}
+	// The restored branch tracks its remote branch again, so the next sync
+	// still recognises it as merged and gone.
+	if remote, merge := git.BranchUpstream(t.Context(), "merged-feature"); remote != "origin" || merge != "refs/heads/merged-feature" {
+		t.Errorf("merged-feature upstream = %q %q, want origin refs/heads/merged-feature", remote, merge)
+	}
+
+	// The journal is consumed, so a second undo has nothing to do.
+	if _, err := undo(t.Context(), &stdout, &stderr, options{}); err == nil || !strings.Contains(err.Error(), "nothing to undo") {
+		t.Errorf("second undo error = %v, want nothing to undo", err)
+	}
+}
+
+func TestUndo_AfterNoOpRun(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("topic", "topic.txt", "topic\n")
+	env.mergeOnRemote("topic")
+	env.deleteRemoteBranch("topic")
+	env.chdir()
+
+	if _, stderr, err := runSync(t); err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+	if git.HasRef(t.Context(), "refs/heads/topic") {
+		t.Fatal("topic should have been deleted by sync")
+	}
+
+	// The second run changes nothing, so it is the last run and there is
+	// nothing to undo.
+	if _, stderr, err := runSync(t); err != nil {
+		t.Fatalf("second sync error: %v\nstderr: %s", err, stderr)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := undo(t.Context(), &stdout, &stderr, options{}); err == nil || !strings.Contains(err.Error(), "nothing to undo") {
+		t.Errorf("undo error = %v, want nothing to undo", err)
+	}
+	if git.HasRef(t.Context(), "refs/heads/topic") {
+		t.Error("undo restored a branch deleted by an earlier run")
+	}
+}
+
+func TestUndo_RefusesWhenBranchMoved(t *testing.T) {
+	env := newTestEnv(t)
+	env.createBranch("feature", "feature.txt", "v1\n")
+	env.addRemoteCommit("feature", "feature.txt", "v2\n")
+	env.chdir()
+
+	if _, stderr, err := runSync(t); err != nil {
+		t.Fatalf("sync error: %v\nstderr: %s", err, stderr)
+	}
+
+	// Someone commits on top of the fast-forwarded branch.
+	mustExec(t, env.local, "git", "checkout", "feature")
+	mustExec(t, env.local, "git", "commit", "--allow-empty", "-m", "new work")
+	mustExec(t, env.local, "git", "checkout", "main")
+	moved := mustExec(t, env.local, "git", "rev-parse", "feature")
+
+	var stdout, stderr bytes.Buffer
+	if _, err := undo(t.Context(), &stdout, &stderr, options{}); err == nil {
+		t.Fatal("expected undo to refuse after the branch moved")
+	}
+	if got := mustExec(t, env.local, "git", "rev-parse", "feature"); got != moved {
+		t.Errorf("feature = %s, want it left at %s", got, moved)
+	}
+}